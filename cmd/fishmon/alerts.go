@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// Alerts evaluates the alert rules of a configuration file against readings,
+// logging when an alert fires and when it resolves.
+type Alerts struct {
+	conf  *config.File
+	state map[alertKey]*alertState
+}
+
+// alertKey identifies an alert rule applied to a single sensor.
+type alertKey struct {
+	rule   int
+	sensor sensor.ID
+}
+
+type alertState struct {
+	since  time.Time
+	firing bool
+}
+
+// NewAlerts constructs an evaluator for a configuration's alert rules.
+func NewAlerts(conf *config.File) *Alerts {
+	return &Alerts{
+		conf:  conf,
+		state: make(map[alertKey]*alertState),
+	}
+}
+
+// SetConfig switches to the alert rules of a reloaded configuration. If the
+// rules changed, alerts that were pending or firing are forgotten.
+func (a *Alerts) SetConfig(conf *config.File) {
+	if conf == a.conf {
+		return
+	}
+	if !reflect.DeepEqual(conf.Alerts, a.conf.Alerts) {
+		a.state = make(map[alertKey]*alertState)
+	}
+	a.conf = conf
+}
+
+// Observe checks a reading against every alert rule that applies to its
+// sensor.
+func (a *Alerts) Observe(r sensor.Reading) {
+	for i, rule := range a.conf.Alerts {
+		if !a.applies(rule, r.Sensor) {
+			continue
+		}
+		converted, err := r.Convert(rule.Unit)
+		if err != nil {
+			continue
+		}
+		key := alertKey{rule: i, sensor: r.Sensor}
+		s, ok := a.state[key]
+		if !ok {
+			s = &alertState{}
+			a.state[key] = s
+		}
+
+		name := a.conf.Sensors[r.Sensor].Name
+		if inRange(rule, converted.Value) {
+			if s.firing {
+				log.Printf("alert %q resolved for sensor %s (%s): %s\n", rule.Name, r.Sensor, name, converted)
+			}
+			*s = alertState{}
+			continue
+		}
+		if s.since.IsZero() {
+			s.since = r.Time
+		}
+		if !s.firing && r.Time.Sub(s.since) >= time.Duration(rule.For) {
+			s.firing = true
+			log.Printf("alert %q firing for sensor %s (%s): %s out of range since %s\n", rule.Name, r.Sensor, name, converted, s.since)
+		}
+	}
+}
+
+// applies returns whether an alert rule covers a sensor.
+func (a *Alerts) applies(rule config.Alert, id sensor.ID) bool {
+	if rule.Sensor != "" {
+		return rule.Sensor == id
+	}
+	s, ok := a.conf.Sensors[id]
+	return ok && s.Tank == rule.Tank
+}
+
+func inRange(rule config.Alert, v float64) bool {
+	if rule.Min != nil && v < *rule.Min {
+		return false
+	}
+	if rule.Max != nil && v > *rule.Max {
+		return false
+	}
+	return true
+}
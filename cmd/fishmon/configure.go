@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// ConfigureProbes writes the configured resolution and alarm thresholds to each
+// DS18B20 probe whose settings differ from its current ones.
+func ConfigureProbes(sensors []sensor.Sensor, conf *config.File) {
+	for _, s := range sensors {
+		probe, ok := s.(*ds18b20.Probe)
+		if !ok {
+			continue
+		}
+		sconf := conf.Sensors[probe.ID()]
+		if sconf.Resolution != 0 {
+			setResolution(probe, sconf.Resolution)
+		}
+		if sconf.Alarms != nil {
+			setAlarms(probe, ds18b20.Alarms{Low: sconf.Alarms.Low, High: sconf.Alarms.High})
+		}
+	}
+}
+
+// A Configurer configures probes in the background, because writing to a
+// probe's EEPROM takes long enough to hold up readings. Only the latest
+// request is kept while probes are being configured, so runs do not overlap.
+type Configurer struct {
+	requests chan configureRequest
+	done     chan struct{}
+}
+
+type configureRequest struct {
+	sensors []sensor.Sensor
+	conf    *config.File
+}
+
+// NewConfigurer starts configuring probes in the background.
+func NewConfigurer() *Configurer {
+	c := &Configurer{
+		requests: make(chan configureRequest, 1),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Configure requests that probes be configured, replacing any request that has
+// not started yet. It must not be called concurrently.
+func (c *Configurer) Configure(sensors []sensor.Sensor, conf *config.File) {
+	select {
+	case <-c.requests:
+	default:
+	}
+	c.requests <- configureRequest{sensors: sensors, conf: conf}
+}
+
+// Close waits for probes to finish being configured, and stops.
+func (c *Configurer) Close() {
+	close(c.requests)
+	<-c.done
+}
+
+func (c *Configurer) run() {
+	defer close(c.done)
+	for r := range c.requests {
+		ConfigureProbes(r.sensors, r.conf)
+	}
+}
+
+func setResolution(probe *ds18b20.Probe, want int) {
+	if have, err := probe.Resolution(); err == nil && have == want {
+		return
+	}
+	if err := probe.SetResolution(want); err != nil {
+		log.Printf("could not set resolution of probe %s to %d bits: %s\n", probe.ID(), want, err.Error())
+		return
+	}
+	conv, err := probe.ConversionTime()
+	if err != nil {
+		conv = ds18b20.ConversionTime(want)
+	}
+	log.Printf("set resolution of probe %s to %d bits, converting in %s\n", probe.ID(), want, conv)
+}
+
+func setAlarms(probe *ds18b20.Probe, want ds18b20.Alarms) {
+	if have, err := probe.Alarms(); err == nil && have == want {
+		return
+	}
+	if err := probe.SetAlarms(want); err != nil {
+		log.Printf("could not set alarms of probe %s to %d°C and %d°C: %s\n", probe.ID(), want.Low, want.High, err.Error())
+		return
+	}
+	log.Printf("set alarms of probe %s to %d°C and %d°C\n", probe.ID(), want.Low, want.High)
+}
+
+// DiagnoseProbe logs wiring problems that a newly attached probe reports:
+// parasite power, and a scratchpad that fails its CRC check.
+func DiagnoseProbe(probe *ds18b20.Probe) {
+	if mode, err := probe.PowerMode(); err == nil && mode == ds18b20.Parasite {
+		log.Printf("probe %s is parasite powered, which makes reads less reliable on long cables; connect its VDD pin to 3.3V if you can\n", probe.ID())
+	}
+	if pad, err := probe.Scratchpad(); err == ds18b20.ErrCRC {
+		log.Printf("probe %s scratchpad %s failed its CRC check, which suggests a loose data wire or missing pull-up resistor\n", probe.ID(), pad)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// Filters rejects glitched temperature readings, with a filter for each
+// sensor. A Filters is safe for concurrent use, but each sensor's readings must
+// be filtered in order.
+type Filters struct {
+	mu      sync.Mutex
+	filters map[sensor.ID]*ds18b20.Filter
+}
+
+// NewFilters constructs filters with no history.
+func NewFilters() *Filters {
+	return &Filters{filters: make(map[sensor.ID]*ds18b20.Filter)}
+}
+
+// Filter checks a reading against its sensor's recent readings, and returns
+// the despiked reading or the reason it was rejected. Only temperature readings
+// are filtered.
+func (f *Filters) Filter(r sensor.Reading, c config.Filter) (sensor.Reading, error) {
+	if r.Kind != sensor.Temperature {
+		return r, nil
+	}
+	celsius, err := r.Convert(sensor.Celsius)
+	if err != nil {
+		return r, nil
+	}
+
+	f.mu.Lock()
+	filter, ok := f.filters[r.Sensor]
+	if !ok {
+		filter = ds18b20.NewFilter(c.MaxRate, c.MedianWindow)
+		f.filters[r.Sensor] = filter
+	}
+	f.mu.Unlock()
+	filter.MaxRate, filter.Window = c.MaxRate, c.MedianWindow
+
+	t, err := filter.Filter(ds18b20.Temperature(celsius.Value), celsius.Time)
+	if err != nil {
+		return r, err
+	}
+	celsius.Value = float64(t.Celsius())
+	return celsius.Convert(r.Unit)
+}
+
+// Forget drops the history of a sensor, such as one that was detached.
+func (f *Filters) Forget(id sensor.ID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.filters, id)
+}
+
+// RejectReason classifies why a reading was rejected as "power_on_reset",
+// "disconnected" or "step".
+func RejectReason(err error) string {
+	switch errors.Cause(err) {
+	case ds18b20.ErrPowerOnReset:
+		return "power_on_reset"
+	case ds18b20.ErrDisconnected:
+		return "disconnected"
+	case ds18b20.ErrImplausibleStep:
+		return "step"
+	}
+	return "other"
+}
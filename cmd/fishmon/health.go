@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// RetryDelay is how long to wait before retrying a read that failed its CRC
+// check.
+const RetryDelay = 100 * time.Millisecond
+
+// Health tracks consecutive read failures of each sensor, and quarantines
+// sensors that keep failing so that they are read less often. A Health is safe
+// for concurrent use.
+type Health struct {
+	mu     sync.Mutex
+	probes map[sensor.ID]*probeHealth
+}
+
+type probeHealth struct {
+	failures int
+	backoff  time.Duration
+	until    time.Time
+}
+
+// NewHealth constructs a health tracker with every sensor healthy.
+func NewHealth() *Health {
+	return &Health{probes: make(map[sensor.ID]*probeHealth)}
+}
+
+// get returns a sensor's state. It must be called with the lock held.
+func (h *Health) get(id sensor.ID) *probeHealth {
+	p, ok := h.probes[id]
+	if !ok {
+		p = &probeHealth{}
+		h.probes[id] = p
+	}
+	return p
+}
+
+// Due returns whether a sensor should be read now. Quarantined sensors are not
+// due until their backoff has passed.
+func (h *Health) Due(id sensor.ID, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !now.Before(h.get(id).until)
+}
+
+// Quarantined returns whether a sensor is quarantined.
+func (h *Health) Quarantined(id sensor.ID) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.get(id).backoff != 0
+}
+
+// Failures returns the number of rounds in a row that a sensor has failed.
+func (h *Health) Failures(id sensor.ID) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.get(id).failures
+}
+
+// Success records a successful read.
+func (h *Health) Success(id sensor.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := h.get(id)
+	if p.backoff != 0 {
+		log.Printf("sensor %s recovered after %d failed rounds, leaving quarantine\n", id, p.failures)
+	}
+	*p = probeHealth{}
+}
+
+// Failure records a failed round of reads, quarantining the sensor if it has
+// failed too many rounds in a row.
+func (h *Health) Failure(id sensor.ID, s config.Sampling, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := h.get(id)
+	p.failures++
+	if p.failures < s.QuarantineAfter {
+		return
+	}
+	switch {
+	case p.backoff == 0:
+		p.backoff = time.Duration(s.QuarantineBackoff)
+	case p.backoff < time.Duration(s.MaxBackoff):
+		p.backoff *= 2
+	}
+	if p.backoff > time.Duration(s.MaxBackoff) {
+		p.backoff = time.Duration(s.MaxBackoff)
+	}
+	p.until = now.Add(p.backoff)
+	log.Printf("sensor %s failed %d rounds in a row, quarantined until %s\n", id, p.failures, p.until.Format(time.RFC3339))
+}
+
+// Forget drops the state of a sensor, such as one that was detached.
+func (h *Health) Forget(id sensor.ID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.probes, id)
+}
+
+// ReadWithRetry takes a reading, retrying reads that fail their CRC check up
+// to attempts times in total. CRC errors are usually transient noise on the
+// 1-Wire bus.
+func ReadWithRetry(ctx context.Context, s sensor.Sensor, attempts int) (sensor.Reading, error) {
+	for attempt := 1; ; attempt++ {
+		reading, err := s.Read(ctx)
+		if err == nil || errors.Cause(err) != ds18b20.ErrCRC || attempt >= attempts {
+			return reading, err
+		}
+		select {
+		case <-ctx.Done():
+			return reading, err
+		case <-time.After(RetryDelay):
+		}
+	}
+}
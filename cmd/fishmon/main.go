@@ -2,23 +2,38 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
 	"time"
 
 	"github.com/goodbuns/fishmon/config"
-	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/aggregate"
 	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+	"github.com/goodbuns/fishmon/pkg/sink"
 )
 
 // Configurable constants.
 const (
-	RateLimitPerMinute = 30
+	// UploadShare is the fraction of the Adafruit.IO rate limit used for new
+	// readings. The rest is left so that readings queued during an outage can
+	// catch up.
+	UploadShare = 2.0 / 3.0
 )
 
+// UploadInterval returns the interval between uploads of n readings that keeps
+// new readings within UploadShare of a rate limit, in data points per minute.
+func UploadInterval(rateLimit, n int) time.Duration {
+	return time.Duration(float64(time.Minute) * float64(n) / (float64(rateLimit) * UploadShare))
+}
+
 func main() {
 	rand.Seed(time.Now().Unix())
 
@@ -26,23 +41,47 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), `%s starts the fishmon service.
 
-Fishmon reads the outputs of connected DS18B20 temperature probes and uploads
-them to Adafruit.IO.
+Fishmon reads the outputs of connected sensors (such as DS18B20 temperature
+probes) and sends them to Adafruit.IO, and optionally to a local file, InfluxDB,
+a Prometheus Pushgateway or an MQTT server.
 
 Usage of %s:
 `, os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
-	aioUser := flag.String("aio_username", "", "Adafruit.IO username")
-	aioKey := flag.String("aio_key", "", "Adafruit.IO key")
+	var sinkFlags SinkFlags
+	sinkFlags.Register(flag.CommandLine)
 	configFile := flag.String("config", "fishmonconfig.json", "Fishmon configuration file")
+	scanInterval := flag.Duration("scan_interval", ds18b20.DefaultScanInterval, "How often to rescan the 1-Wire bus for attached and detached probes")
+	metricsAddr := flag.String("metrics_addr", "", "Address to serve Prometheus metrics and probe status on, such as :9101 (leave empty to disable)")
 	flag.Parse()
 
-	// Parse configuration.
-	conf, err := config.New(*configFile)
+	// Parse configuration. Sinks set up on the command line take precedence
+	// over the configuration file.
+	watcher, err := config.Watch(*configFile)
 	if err != nil {
 		log.Fatalf("could not parse configuration file at %s: %s", *configFile, err.Error())
 	}
+	defer watcher.Close()
+	reloaded := make(chan struct{}, 1)
+	watcher.OnReload = func(old, new *config.File) {
+		log.Printf("reloaded configuration file at %s\n", *configFile)
+		if !reflect.DeepEqual(old.Sinks, new.Sinks) {
+			log.Printf("sink configuration changes take effect after fishmon restarts\n")
+		}
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	}
+	go reloadOnHangup(watcher)
+	conf := watcher.Config()
+	sinkFlags.ApplyConfig(flag.CommandLine, conf.Sinks)
+
+	tier, err := sinkFlags.Tier()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Set up system.
 	err = ds18b20.Ensure()
@@ -50,52 +89,215 @@ Usage of %s:
 		log.Fatalf("could not set up DS18B20 probe: %s", err.Error())
 	}
 
-	// Set up sensors.
-	sensors, err := ds18b20.Sensors()
+	// Set up metrics and status.
+	m := NewMetrics()
+	status := &Status{}
+	if *metricsAddr != "" {
+		m.Serve(*metricsAddr, status)
+	}
+	sinkFlags.ObserveUpload = m.ObserveUpload
+
+	// Set up sinks.
+	sinks, err := sinkFlags.Sinks()
 	if err != nil {
-		log.Fatalf("could not detect DS18B20 sensors: %s", err.Error())
+		log.Fatalf("could not set up sinks: %s", err.Error())
 	}
-	log.Printf("found %d sensors: %#v\n", len(sensors), sensors)
+	fanout := sink.NewFanout(sink.DefaultBufferSize, sink.DefaultSendTimeout, sinks...)
+	fanout.Observe = m.ObserveSink
+	defer fanout.Close()
 
-	var probes []*ds18b20.Probe
-	for _, sensor := range sensors {
-		probe, err := ds18b20.New(sensor)
+	// Set up handling of unconfigured probes. The Adafruit.IO client is the
+	// sink's, so that feed creation shares its rate limit.
+	client, err := sinkFlags.AIOClient()
+	if err != nil {
+		log.Fatalf("could not set up unconfigured probe handling: %s", err.Error())
+	}
+	unconfigured := NewUnconfigured(client, sinkFlags.AIOUser)
+
+	// Set up sensors. Each probe is read in its own goroutine. A sensor that
+	// fails does not hold up the others, and one that keeps failing is
+	// quarantined. Glitched readings are rejected without counting as
+	// failures, since the probe did respond.
+	health := NewHealth()
+	filters := NewFilters()
+	sample := func(ctx context.Context, s sensor.Sensor) (sensor.Reading, bool) {
+		conf := watcher.Config()
+		sampling := conf.Sampling.WithDefaults()
+		id, name := s.ID(), conf.Sensors[s.ID()].Name
+		now := time.Now()
+		if !health.Due(id, now) {
+			return sensor.Reading{}, false
+		}
+		reading, err := ReadWithRetry(ctx, s, sampling.Attempts)
 		if err != nil {
-			log.Fatalf("could not set up probe %s: %s", sensor, err.Error())
+			if ctx.Err() != nil {
+				return sensor.Reading{}, false
+			}
+			health.Failure(id, sampling, now)
+			m.ObserveReadError(id, name, err)
+			m.ObserveHealth(id, name, health.Failures(id), health.Quarantined(id))
+			log.Printf("failed to read %s sensor %s: %s\n", s.Kind(), id, err.Error())
+			return sensor.Reading{}, false
+		}
+		health.Success(id)
+		m.ObserveHealth(id, name, 0, false)
+		filtered, err := filters.Filter(reading, sampling.Filter)
+		if err != nil {
+			m.ObserveRejected(id, name, err)
+			log.Printf("rejected reading %0.3f%s from %s sensor %s: %s\n", reading.Value, reading.Unit, s.Kind(), id, err.Error())
+			return sensor.Reading{}, false
 		}
-		probes = append(probes, probe)
+		return filtered, true
 	}
+	probes := NewProbes(sample, func(id sensor.ID) time.Duration {
+		return watcher.Config().SampleInterval(id)
+	})
+	defer probes.Close()
 
-	// Set up Adafruit.IO client.
-	client, err := adafruitio.New(*aioUser, *aioKey)
+	// The bus is rescanned so that probes can be attached and detached while
+	// fishmon runs.
+	hotplug, err := ds18b20.Watch(*scanInterval)
 	if err != nil {
-		log.Fatalf("could not set up Adafruit.IO client: %s", err.Error())
+		log.Fatalf("could not detect DS18B20 sensors: %s", err.Error())
+	}
+	defer hotplug.Close()
+	for len(hotplug.Events()) > 0 {
+		probes.Handle(<-hotplug.Events())
+	}
+	configurer := NewConfigurer()
+	defer configurer.Close()
+	configurer.Configure(probes.Sensors(), conf)
+	log.Printf("found %d sensors\n", len(probes.Sensors()))
+	if len(probes.Sensors()) == 0 {
+		log.Printf("waiting for sensors: %s\n", ds18b20.ErrNoSlaves.Error())
 	}
 
-	// Monitor and report temperature data.
-	// Adafruit.IO limits free accounts to 30 data points per minute.
-	rate := time.Minute / (RateLimitPerMinute / time.Duration(len(probes)))
-	ticker := time.NewTicker(rate)
+	// Report which probes are configured.
+	status.Update(probes.Sensors(), conf, unconfigured)
+	for _, line := range status.Lines() {
+		log.Println(line)
+	}
 
-	for range ticker.C {
-		for _, probe := range probes {
-			timestamp := time.Now()
+	// Upload statistics of each sensor's readings on a separate schedule from
+	// sampling, so that sensors can be sampled more often than the
+	// Adafruit.IO rate limit allows uploads.
+	uploadInterval := func(conf *config.File) time.Duration {
+		if conf.Sampling.UploadInterval != 0 {
+			return time.Duration(conf.Sampling.UploadInterval)
+		}
+		n := 0
+		for _, s := range probes.Sensors() {
+			sconf, ok := conf.Sensors[s.ID()]
+			if !ok {
+				// Only the mean of unconfigured sensors is reported.
+				n++
+				continue
+			}
+			n += len(sconf.StatisticFeeds())
+		}
+		if n == 0 {
+			n = 1
+		}
+		return UploadInterval(tier.RateLimit(), n)
+	}
+	interval := uploadInterval(conf)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	reschedule := func(conf *config.File) {
+		if next := uploadInterval(conf); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+	}
+	alerts := NewAlerts(conf)
+	aggregator := aggregate.New()
+	loaded := conf
 
-			// Sense temperature.
-			temperature, err := probe.Sense()
-			if err != nil {
-				log.Printf("failed to sense temperature for probe %s: %s\n", probe.ID, err.Error())
-				break
+	for {
+		select {
+		case e := <-hotplug.Events():
+			probes.Handle(e)
+			if e.Op == ds18b20.Removed {
+				health.Forget(e.ID)
+				filters.Forget(e.ID)
+				m.Forget(e.ID)
+			} else {
+				configurer.Configure(probes.Sensors(), watcher.Config())
 			}
+			reschedule(watcher.Config())
+			status.Update(probes.Sensors(), watcher.Config(), unconfigured)
 
-			// Report temperature.
-			pconf, ok := conf.Probes[probe.ID]
-			if !ok {
-				log.Fatalf("could not find configuration for probe %s", probe.ID)
+		case <-reloaded:
+			conf := watcher.Config()
+			for _, s := range probes.Sensors() {
+				if id := s.ID(); conf.Sensors[id].Name != loaded.Sensors[id].Name {
+					m.Forget(id)
+				}
+			}
+			loaded = conf
+			alerts.SetConfig(conf)
+			configurer.Configure(probes.Sensors(), conf)
+			probes.Reconfigure()
+			reschedule(conf)
+			status.Update(probes.Sensors(), conf, unconfigured)
+
+		case reading := <-probes.Readings():
+			conf := watcher.Config()
+			name := conf.Sensors[reading.Sensor].Name
+			if name == "" {
+				name = string(reading.Sensor)
 			}
-			client.Record(pconf.FeedKey, fmt.Sprintf("%.3f", temperature.Fahrenheit()), timestamp)
+			m.ObserveReading(sink.Reading{Reading: reading, Name: name})
+			alerts.Observe(reading)
+			aggregator.Add(reading)
+
+			fmt.Printf("time=%s sensor=%s kind=%s value=%0.3f%s\n", reading.Time.String(), reading.Sensor, reading.Kind, reading.Value, reading.Unit)
+
+		case <-ticker.C:
+			// Report each sensor's statistics since the last upload. Only
+			// the mean of unconfigured sensors is reported.
+			conf := watcher.Config()
+			for _, summary := range aggregator.Flush() {
+				mean := summary.Reading(aggregate.Mean)
+				sconf, ok := conf.Sensors[summary.Sensor]
+				if !ok {
+					if labelled, send := unconfigured.Label(conf.Unconfigured, mean); send {
+						fanout.Send(labelled)
+					}
+					continue
+				}
+				// Sensors without a feed for the mean only report the
+				// statistics that they have feeds for.
+				feeds := sconf.StatisticFeeds()
+				if feed, ok := feeds[aggregate.Mean]; ok {
+					fanout.Send(sink.Reading{Reading: mean, Name: sconf.Name, Feed: feed})
+				}
+				for _, stat := range aggregate.Statistics {
+					feed, ok := feeds[stat]
+					if !ok || stat == aggregate.Mean {
+						continue
+					}
+					fanout.Send(sink.Reading{
+						Reading:   summary.Reading(stat),
+						Name:      sconf.Name,
+						Statistic: string(stat),
+						Feed:      feed,
+					})
+				}
+			}
+			status.Update(probes.Sensors(), conf, unconfigured)
+		}
+	}
+}
 
-			fmt.Printf("time=%s probe=%s temp=%0.3f\n", timestamp.String(), probe.ID, temperature.Fahrenheit())
+// reloadOnHangup reloads the configuration file whenever fishmon receives
+// SIGHUP.
+func reloadOnHangup(w *config.Watcher) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := w.Reload(); err != nil {
+			log.Printf("keeping current configuration, could not reload: %s\n", err.Error())
 		}
 	}
 }
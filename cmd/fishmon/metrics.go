@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/metrics"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+	"github.com/goodbuns/fishmon/pkg/sink"
+)
+
+// Metrics are the Prometheus metrics exported by fishmon.
+type Metrics struct {
+	Registry *metrics.Registry
+
+	Temperature    *metrics.Gauge
+	Reading        *metrics.Gauge
+	ReadErrors     *metrics.Counter
+	Rejected       *metrics.Counter
+	Failures       *metrics.Gauge
+	Quarantined    *metrics.Gauge
+	SinkErrors     *metrics.Counter
+	SinkDuration   *metrics.Histogram
+	UploadFailures *metrics.Counter
+	UploadDuration *metrics.Histogram
+	UploadPoints   *metrics.Counter
+}
+
+// NewMetrics registers fishmon's metrics.
+func NewMetrics() *Metrics {
+	r := metrics.NewRegistry()
+	return &Metrics{
+		Registry: r,
+		Temperature: r.NewGauge("fishmon_temperature_celsius",
+			"Latest temperature reading of a probe, in degrees Celsius.", "id", "name"),
+		Reading: r.NewGauge("fishmon_reading",
+			"Latest reading of a sensor, in the sensor's unit.", "id", "name", "kind", "unit"),
+		ReadErrors: r.NewCounter("fishmon_read_errors_total",
+			"Failed sensor reads, by reason (crc, parse or other).", "id", "name", "reason"),
+		Rejected: r.NewCounter("fishmon_rejected_readings_total",
+			"Glitched readings rejected by filtering, by reason (power_on_reset, disconnected or step).", "id", "name", "reason"),
+		Failures: r.NewGauge("fishmon_consecutive_failures",
+			"Rounds in a row that a sensor has failed to read.", "id", "name"),
+		Quarantined: r.NewGauge("fishmon_quarantined",
+			"Whether a sensor is quarantined for failing repeatedly (1) or not (0).", "id", "name"),
+		SinkErrors: r.NewCounter("fishmon_sink_errors_total",
+			"Readings that could not be sent to a sink.", "sink"),
+		SinkDuration: r.NewHistogram("fishmon_sink_duration_seconds",
+			"Time taken to send a reading to a sink.", nil, "sink"),
+		UploadFailures: r.NewCounter("fishmon_upload_failures_total",
+			"Failed Adafruit.IO upload requests.", "feed"),
+		UploadDuration: r.NewHistogram("fishmon_upload_duration_seconds",
+			"Latency of Adafruit.IO upload requests.", nil, "feed"),
+		UploadPoints: r.NewCounter("fishmon_upload_points_total",
+			"Points uploaded to Adafruit.IO.", "feed"),
+	}
+}
+
+// ObserveReading records a successful reading.
+func (m *Metrics) ObserveReading(r sink.Reading) {
+	m.Reading.Set(r.Value, string(r.Sensor), r.Name, string(r.Kind), string(r.Unit))
+	if r.Kind == sensor.Temperature {
+		if c, err := r.Reading.Convert(sensor.Celsius); err == nil {
+			m.Temperature.Set(c.Value, string(r.Sensor), r.Name)
+		}
+	}
+}
+
+// Forget removes a sensor's gauges, so that a removed or renamed sensor does
+// not keep reporting its last values. They are set again by the sensor's next
+// reading.
+func (m *Metrics) Forget(id sensor.ID) {
+	for _, g := range []*metrics.Gauge{m.Temperature, m.Reading, m.Failures, m.Quarantined} {
+		g.DeleteMatching("id", string(id))
+	}
+}
+
+// ObserveReadError records a failed reading.
+func (m *Metrics) ObserveReadError(id sensor.ID, name string, err error) {
+	m.ReadErrors.Inc(string(id), name, ReadErrorReason(err))
+}
+
+// ObserveRejected records a reading rejected by filtering.
+func (m *Metrics) ObserveRejected(id sensor.ID, name string, err error) {
+	m.Rejected.Inc(string(id), name, RejectReason(err))
+}
+
+// ObserveHealth records a sensor's consecutive failures and quarantine state.
+func (m *Metrics) ObserveHealth(id sensor.ID, name string, failures int, quarantined bool) {
+	m.Failures.Set(float64(failures), string(id), name)
+	q := 0.0
+	if quarantined {
+		q = 1
+	}
+	m.Quarantined.Set(q, string(id), name)
+}
+
+// ReadErrorReason classifies a sensor read error as "crc", "parse" or "other".
+func ReadErrorReason(err error) string {
+	switch cause := errors.Cause(err); cause.(type) {
+	case *strconv.NumError:
+		return "parse"
+	default:
+		switch cause {
+		case ds18b20.ErrCRC:
+			return "crc"
+		case ds18b20.ErrInvalidOutput:
+			return "parse"
+		}
+	}
+	return "other"
+}
+
+// ObserveSink records a send to a sink.
+func (m *Metrics) ObserveSink(s sink.Sink, d time.Duration, err error) {
+	if err != nil {
+		m.SinkErrors.Inc(s.Name())
+		return
+	}
+	m.SinkDuration.Observe(d.Seconds(), s.Name())
+}
+
+// ObserveUpload records an Adafruit.IO upload request.
+func (m *Metrics) ObserveUpload(feed string, points int, d time.Duration, err error) {
+	m.UploadDuration.Observe(d.Seconds(), feed)
+	if err != nil {
+		m.UploadFailures.Inc(feed)
+		return
+	}
+	m.UploadPoints.Add(float64(points), feed)
+}
+
+// Serve serves metrics at /metrics and status at /status on addr in the
+// background.
+func (m *Metrics) Serve(addr string, status http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Registry)
+	mux.Handle("/status", status)
+	go func() {
+		log.Printf("serving metrics on %s\n", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("could not serve metrics on %s: %s", addr, err.Error())
+		}
+	}()
+}
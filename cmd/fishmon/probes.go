@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// ReadingsBufferSize is the number of readings that samplers can send before
+// they wait for readings to be received.
+const ReadingsBufferSize = 64
+
+// Probes is the set of attached DS18B20 probes, which changes as probes are
+// attached and detached. Each probe is sampled in its own goroutine at its own
+// interval, and readings are sent to a single channel.
+type Probes struct {
+	// Sample takes a reading from a sensor, and returns false if there is no
+	// reading to send, such as when the read failed. It is called from the
+	// sensors' goroutines.
+	Sample func(ctx context.Context, s sensor.Sensor) (sensor.Reading, bool)
+	// Interval returns the time between readings of a sensor.
+	Interval func(id sensor.ID) time.Duration
+
+	readings chan sensor.Reading
+	samplers map[sensor.ID]*sampler
+}
+
+// A sampler reads a single sensor in the background.
+type sampler struct {
+	sensor   sensor.Sensor
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewProbes constructs an empty set of probes.
+func NewProbes(sample func(ctx context.Context, s sensor.Sensor) (sensor.Reading, bool), interval func(id sensor.ID) time.Duration) *Probes {
+	return &Probes{
+		Sample:   sample,
+		Interval: interval,
+		readings: make(chan sensor.Reading, ReadingsBufferSize),
+		samplers: make(map[sensor.ID]*sampler),
+	}
+}
+
+// Readings returns the channel that readings from every probe are sent on.
+func (p *Probes) Readings() <-chan sensor.Reading {
+	return p.readings
+}
+
+// Handle updates the set of probes for a hotplug event.
+func (p *Probes) Handle(e ds18b20.Event) {
+	switch e.Op {
+	case ds18b20.Added:
+		if _, ok := p.samplers[e.ID]; ok {
+			return
+		}
+		probe, err := ds18b20.New(e.ID)
+		if err != nil {
+			log.Printf("could not set up probe %s: %s\n", e.ID, err.Error())
+			return
+		}
+		p.start(probe)
+		log.Printf("probe %s attached\n", e.ID)
+		go DiagnoseProbe(probe)
+	case ds18b20.Removed:
+		s, ok := p.samplers[e.ID]
+		if !ok {
+			return
+		}
+		p.stop(s)
+		delete(p.samplers, e.ID)
+		if c, ok := s.sensor.(interface{ Close() error }); ok {
+			c.Close()
+		}
+		log.Printf("probe %s detached\n", e.ID)
+	}
+}
+
+// Reconfigure restarts the samplers of sensors whose intervals have changed.
+func (p *Probes) Reconfigure() {
+	for id, s := range p.samplers {
+		if p.Interval(id) != s.interval {
+			p.stop(s)
+			p.start(s.sensor)
+		}
+	}
+}
+
+// Sensors returns the attached probes, ordered by ID.
+func (p *Probes) Sensors() []sensor.Sensor {
+	sensors := make([]sensor.Sensor, 0, len(p.samplers))
+	for _, s := range p.samplers {
+		sensors = append(sensors, s.sensor)
+	}
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID() < sensors[j].ID() })
+	return sensors
+}
+
+// Close stops sampling every probe. It does not close the probes.
+func (p *Probes) Close() {
+	for _, s := range p.samplers {
+		p.stop(s)
+	}
+}
+
+func (p *Probes) start(s sensor.Sensor) {
+	ctx, cancel := context.WithCancel(context.Background())
+	smp := &sampler{
+		sensor:   s,
+		interval: p.Interval(s.ID()),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	p.samplers[s.ID()] = smp
+	go p.run(ctx, smp)
+}
+
+func (p *Probes) stop(s *sampler) {
+	s.cancel()
+	<-s.done
+}
+
+// run samples a sensor until its context is cancelled.
+func (p *Probes) run(ctx context.Context, s *sampler) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if reading, ok := p.Sample(ctx, s.sensor); ok {
+			select {
+			case p.readings <- reading:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
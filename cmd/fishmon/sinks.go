@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/mqtt"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+	"github.com/goodbuns/fishmon/pkg/sink"
+)
+
+// SinkFlags holds the command-line flags that configure sinks.
+type SinkFlags struct {
+	AIOUser      string
+	AIOKey       string
+	AIOURL       string
+	AIOTimeout   time.Duration
+	AIOTransport string
+	AIOMQTTAddr  string
+	AIOMQTTTLS   bool
+	AIOTier      string
+	QueueDir     string
+	// AIOTemperatureUnit is the unit temperatures are uploaded in. It can
+	// only be set in the configuration file.
+	AIOTemperatureUnit sensor.Unit
+
+	File string
+
+	InfluxDBURL   string
+	InfluxDBToken string
+
+	PushgatewayURL string
+
+	MQTTAddr     string
+	MQTTTLS      bool
+	MQTTUser     string
+	MQTTPassword string
+	MQTTClientID string
+	MQTTTopic    string
+
+	// ObserveUpload, if not nil, observes Adafruit.IO upload requests.
+	ObserveUpload func(feed string, points int, d time.Duration, err error)
+
+	// client is the Adafruit.IO client shared by the sink and unconfigured
+	// probe handling, so that they share a rate limit.
+	client *adafruitio.Client
+}
+
+// Register defines the sink flags in a flag set.
+func (f *SinkFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.AIOUser, "aio_username", "", "Adafruit.IO username (leave empty to disable uploading to Adafruit.IO)")
+	fs.StringVar(&f.AIOKey, "aio_key", "", "Adafruit.IO key")
+	fs.StringVar(&f.AIOURL, "aio_url", adafruitio.DefaultBaseURL, "Adafruit.IO API base URL")
+	fs.DurationVar(&f.AIOTimeout, "aio_timeout", adafruitio.DefaultTimeout, "Adafruit.IO API request timeout")
+	fs.StringVar(&f.AIOTransport, "aio_transport", "http", "How live readings are sent to Adafruit.IO (http or mqtt)")
+	fs.StringVar(&f.AIOMQTTAddr, "aio_mqtt_addr", adafruitio.DefaultMQTTAddr, "Adafruit.IO MQTT server address, used with -aio_transport=mqtt")
+	fs.BoolVar(&f.AIOMQTTTLS, "aio_mqtt_tls", true, "Whether to connect to the Adafruit.IO MQTT server using TLS")
+	fs.StringVar(&f.AIOTier, "aio_tier", string(adafruitio.Free), "Adafruit.IO account tier (free or plus), which sets the upload rate limit")
+	fs.StringVar(&f.QueueDir, "queue_dir", "fishmon-queue", "Directory for buffering readings before upload to Adafruit.IO")
+
+	fs.StringVar(&f.File, "file", "", "File to append readings to as JSON lines")
+
+	fs.StringVar(&f.InfluxDBURL, "influxdb_url", "", "InfluxDB write endpoint URL, including database or bucket parameters")
+	fs.StringVar(&f.InfluxDBToken, "influxdb_token", "", "InfluxDB authorization token")
+
+	fs.StringVar(&f.PushgatewayURL, "pushgateway_url", "", "Prometheus Pushgateway base URL")
+
+	fs.StringVar(&f.MQTTAddr, "mqtt_addr", "", "MQTT server address to publish readings to")
+	fs.BoolVar(&f.MQTTTLS, "mqtt_tls", false, "Whether to connect to the MQTT server using TLS")
+	fs.StringVar(&f.MQTTUser, "mqtt_username", "", "MQTT username")
+	fs.StringVar(&f.MQTTPassword, "mqtt_password", "", "MQTT password")
+	fs.StringVar(&f.MQTTClientID, "mqtt_client_id", "fishmon", "MQTT client ID")
+	fs.StringVar(&f.MQTTTopic, "mqtt_topic", sink.DefaultTopicPrefix, "MQTT topic prefix for readings")
+}
+
+// ApplyConfig sets up the sinks in a configuration file. Flags that were set
+// in fs take precedence over the configuration file.
+func (f *SinkFlags) ApplyConfig(fs *flag.FlagSet, c config.Sinks) {
+	set := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+	str := func(name string, dst *string, v string) {
+		if !set[name] && v != "" {
+			*dst = v
+		}
+	}
+
+	if a := c.AdafruitIO; a != nil {
+		str("aio_username", &f.AIOUser, a.Username)
+		str("aio_key", &f.AIOKey, a.Key)
+		str("aio_url", &f.AIOURL, a.URL)
+		if !set["aio_timeout"] && a.Timeout != 0 {
+			f.AIOTimeout = time.Duration(a.Timeout)
+		}
+		str("aio_tier", &f.AIOTier, string(a.Tier))
+		str("aio_transport", &f.AIOTransport, a.Transport)
+		str("aio_mqtt_addr", &f.AIOMQTTAddr, a.MQTTAddr)
+		if !set["aio_mqtt_tls"] && a.MQTTTLS != nil {
+			f.AIOMQTTTLS = *a.MQTTTLS
+		}
+		str("queue_dir", &f.QueueDir, a.QueueDir)
+		f.AIOTemperatureUnit = a.TemperatureUnit
+	}
+	if c.File != nil {
+		str("file", &f.File, c.File.Path)
+	}
+	if c.InfluxDB != nil {
+		str("influxdb_url", &f.InfluxDBURL, c.InfluxDB.URL)
+		str("influxdb_token", &f.InfluxDBToken, c.InfluxDB.Token)
+	}
+	if c.Pushgateway != nil {
+		str("pushgateway_url", &f.PushgatewayURL, c.Pushgateway.URL)
+	}
+	if m := c.MQTT; m != nil {
+		str("mqtt_addr", &f.MQTTAddr, m.Addr)
+		if !set["mqtt_tls"] {
+			f.MQTTTLS = m.TLS
+		}
+		str("mqtt_username", &f.MQTTUser, m.Username)
+		str("mqtt_password", &f.MQTTPassword, m.Password)
+		str("mqtt_client_id", &f.MQTTClientID, m.ClientID)
+		str("mqtt_topic", &f.MQTTTopic, m.Topic)
+	}
+}
+
+// Tier returns the configured Adafruit.IO account tier.
+func (f *SinkFlags) Tier() (adafruitio.Tier, error) {
+	tier := adafruitio.Tier(f.AIOTier)
+	if tier.RateLimit() == 0 {
+		return tier, errors.Errorf("unknown Adafruit.IO account tier %q", f.AIOTier)
+	}
+	return tier, nil
+}
+
+// Sinks sets up the configured sinks.
+func (f *SinkFlags) Sinks() ([]sink.Sink, error) {
+	var sinks []sink.Sink
+	closeAll := func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+
+	if f.AIOUser != "" {
+		s, err := f.adafruitIO()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if f.File != "" {
+		s, err := sink.NewFile(f.File)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if f.InfluxDBURL != "" {
+		sinks = append(sinks, &sink.InfluxDB{
+			URL:   f.InfluxDBURL,
+			Token: f.InfluxDBToken,
+		})
+	}
+	if f.PushgatewayURL != "" {
+		sinks = append(sinks, &sink.Pushgateway{URL: f.PushgatewayURL})
+	}
+	if f.MQTTAddr != "" {
+		opts := mqtt.Options{
+			Addr:     f.MQTTAddr,
+			ClientID: f.MQTTClientID,
+			Username: f.MQTTUser,
+			Password: f.MQTTPassword,
+		}
+		if f.MQTTTLS {
+			tlsConfig, err := tlsConfig(f.MQTTAddr)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			opts.TLSConfig = tlsConfig
+		}
+		s, err := sink.NewMQTT(opts)
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrap(err, "could not set up MQTT sink")
+		}
+		s.TopicPrefix = f.MQTTTopic
+		sinks = append(sinks, s)
+	}
+
+	if len(sinks) == 0 {
+		return nil, errors.New("no sinks configured")
+	}
+	return sinks, nil
+}
+
+// adafruitIO sets up the Adafruit.IO sink.
+func (f *SinkFlags) adafruitIO() (*sink.AdafruitIO, error) {
+	// Set up Adafruit.IO client.
+	client, err := f.AIOClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up MQTT publishing. The publisher connects in the background, and
+	// points are uploaded over HTTP until it does.
+	var publisher *adafruitio.Publisher
+	switch f.AIOTransport {
+	case "http":
+	case "mqtt":
+		opts := mqtt.Options{Addr: f.AIOMQTTAddr}
+		if f.AIOMQTTTLS {
+			if opts.TLSConfig, err = tlsConfig(f.AIOMQTTAddr); err != nil {
+				return nil, err
+			}
+		}
+		publisher = adafruitio.StartPublisher(client, opts)
+	default:
+		return nil, errors.Errorf("unknown Adafruit.IO transport %q", f.AIOTransport)
+	}
+
+	// Set up uploads. Readings are queued on disk before upload, so that they
+	// survive network and Adafruit.IO outages.
+	s, err := sink.NewAdafruitIO(f.QueueDir, client, publisher)
+	if err != nil {
+		if publisher != nil {
+			publisher.Close()
+		}
+		return nil, errors.Wrapf(err, "could not open upload queue at %s", f.QueueDir)
+	}
+	s.Observe = f.ObserveUpload
+	if f.AIOTemperatureUnit != "" {
+		s.TemperatureUnit = f.AIOTemperatureUnit
+	}
+	s.Start()
+	return s, nil
+}
+
+// AIOClient returns the Adafruit.IO client, or nil if uploading to Adafruit.IO
+// is disabled. The client is set up on the first call without contacting
+// Adafruit.IO, so that fishmon can start and queue readings while the network
+// is down, and its credentials are checked in the background.
+func (f *SinkFlags) AIOClient() (*adafruitio.Client, error) {
+	if f.AIOUser == "" || f.client != nil {
+		return f.client, nil
+	}
+	tier, err := f.Tier()
+	if err != nil {
+		return nil, err
+	}
+	f.client = adafruitio.NewUnchecked(f.AIOUser, f.AIOKey,
+		adafruitio.WithBaseURL(f.AIOURL),
+		adafruitio.WithTimeout(f.AIOTimeout),
+		adafruitio.WithTier(tier),
+	)
+	go checkCredentials(f.client)
+	return f.client, nil
+}
+
+// checkCredentials checks a client's credentials, retrying with backoff until
+// Adafruit.IO can be reached, and logs the outcome.
+func checkCredentials(client *adafruitio.Client) {
+	backoff := time.Minute
+	for {
+		err := client.CheckCredentials()
+		if err == nil {
+			log.Printf("Adafruit.IO credentials are valid\n")
+			return
+		}
+		log.Printf("could not check Adafruit.IO credentials, retrying in %s: %s\n", backoff, err.Error())
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 30*time.Minute {
+			backoff = 30 * time.Minute
+		}
+	}
+}
+
+// tlsConfig returns a TLS configuration for connecting to a host:port address.
+func tlsConfig(addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid server address %s", addr)
+	}
+	return &tls.Config{ServerName: host}, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// Status reports which probes are attached, and whether they are configured.
+// A Status is safe for concurrent use.
+type Status struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// Update records the attached probes and the configuration they are read
+// with.
+func (s *Status) Update(probes []sensor.Sensor, conf *config.File, u *Unconfigured) {
+	var lines []string
+	attached := make(map[sensor.ID]bool)
+	for _, p := range probes {
+		id := p.ID()
+		attached[id] = true
+		if sconf, ok := conf.Sensors[id]; ok {
+			lines = append(lines, fmt.Sprintf("probe %s: %q, feed %s", id, sconf.Name, sconf.FeedKey))
+			continue
+		}
+		line := fmt.Sprintf("probe %s: unconfigured, policy %s", id, conf.Unconfigured.PolicyOrDefault())
+		if feed := u.Feed(id); feed != "" {
+			line += ", feed " + feed
+		}
+		lines = append(lines, line)
+	}
+
+	var missing []string
+	for id, sconf := range conf.Sensors {
+		if !attached[id] {
+			missing = append(missing, fmt.Sprintf("probe %s: %q, configured but not attached", id, sconf.Name))
+		}
+	}
+	sort.Strings(missing)
+	lines = append(lines, missing...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = lines
+}
+
+// Lines returns a line of status for each attached or configured probe.
+func (s *Status) Lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+// ServeHTTP serves the status as plain text.
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	lines := s.Lines()
+	if len(lines) == 0 {
+		io.WriteString(w, "no probes attached or configured\n")
+		return
+	}
+	io.WriteString(w, strings.Join(lines, "\n")+"\n")
+}
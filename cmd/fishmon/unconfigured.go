@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+	"github.com/goodbuns/fishmon/pkg/sink"
+)
+
+// CreateFeedRetry is how long to wait before trying again to create a feed for
+// an unconfigured probe.
+const CreateFeedRetry = time.Minute
+
+// Unconfigured handles readings from probes that are attached but not
+// configured, according to the configuration's policy.
+type Unconfigured struct {
+	// Client creates feeds for the CreateFeed policy. If nil, feeds cannot be
+	// created.
+	Client *adafruitio.Client
+	// User is the Adafruit.IO username that feeds are created for.
+	User string
+
+	warned map[sensor.ID]string
+
+	mu       sync.Mutex
+	feeds    map[sensor.ID]string
+	creating map[sensor.ID]bool
+	failed   map[sensor.ID]time.Time
+}
+
+// NewUnconfigured constructs a handler for unconfigured probes.
+func NewUnconfigured(client *adafruitio.Client, user string) *Unconfigured {
+	return &Unconfigured{
+		Client:   client,
+		User:     user,
+		warned:   make(map[sensor.ID]string),
+		feeds:    make(map[sensor.ID]string),
+		creating: make(map[sensor.ID]bool),
+		failed:   make(map[sensor.ID]time.Time),
+	}
+}
+
+// Label labels a reading from an unconfigured probe, and returns whether it
+// should be sent to sinks.
+func (u *Unconfigured) Label(c config.Unconfigured, r sensor.Reading) (sink.Reading, bool) {
+	labelled := sink.Reading{
+		Reading: r,
+		Name:    string(r.Sensor),
+	}
+	switch c.PolicyOrDefault() {
+	case config.Skip:
+		u.warn(r.Sensor, "skipping readings from unconfigured probe %s\n", r.Sensor)
+		return labelled, false
+	case config.LogOnly:
+		u.warn(r.Sensor, "logging readings from unconfigured probe %s locally only\n", r.Sensor)
+		return labelled, false
+	case config.CreateFeed:
+		feed, err := u.feed(c.Group, r.Sensor)
+		if err != nil {
+			u.warn(r.Sensor, "skipping readings from unconfigured probe %s: %s\n", r.Sensor, err.Error())
+			return labelled, false
+		}
+		u.warn(r.Sensor, "sending readings from unconfigured probe %s to feed %s\n", r.Sensor, feed)
+		labelled.Feed = feed
+		return labelled, true
+	default:
+		return labelled, false
+	}
+}
+
+// Feed returns the feed created for an unconfigured probe, if any.
+func (u *Unconfigured) Feed(id sensor.ID) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.feeds[id]
+}
+
+// feed returns the feed for a probe in a group. If there is none yet, it is
+// found or created in the background, so that Adafruit.IO requests do not hold
+// up the caller, and readings are skipped until it is ready.
+func (u *Unconfigured) feed(group string, id sensor.ID) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if feed := u.feeds[id]; feed != "" && strings.HasPrefix(feed, group+".") {
+		return feed, nil
+	}
+	if u.Client == nil {
+		return "", errors.New("Adafruit.IO is not set up")
+	}
+	if u.creating[id] {
+		return "", errors.New("waiting for feed to be created")
+	}
+	if last, ok := u.failed[id]; ok && time.Since(last) < CreateFeedRetry {
+		return "", errors.New("waiting to retry creating feed")
+	}
+	u.creating[id] = true
+	go u.create(group, id)
+	return "", errors.New("waiting for feed to be created")
+}
+
+// create finds or creates the feed for a probe in a group.
+func (u *Unconfigured) create(group string, id sensor.ID) {
+	feed, err := u.findOrCreate(group, strings.ToLower(string(id)))
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.creating, id)
+	if err != nil {
+		log.Printf("could not create feed for unconfigured probe %s: %s\n", id, err.Error())
+		u.failed[id] = time.Now()
+		return
+	}
+	delete(u.failed, id)
+	u.feeds[id] = feed
+}
+
+func (u *Unconfigured) findOrCreate(group, key string) (string, error) {
+	feeds, err := u.Client.Group(u.User, group)
+	if err == nil {
+		for _, f := range feeds {
+			if f.Key == group+"."+key {
+				return f.Key, nil
+			}
+		}
+	}
+	created, err := u.Client.CreateFeed(group, adafruitio.FeedRequest{Name: key, Key: key})
+	if err != nil {
+		return "", errors.Wrapf(err, "could not create feed %s in group %s", key, group)
+	}
+	return created.Key, nil
+}
+
+// warn logs a message about a probe, unless it is the same as the last message
+// about the probe.
+func (u *Unconfigured) warn(id sensor.ID, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if u.warned[id] == msg {
+		return
+	}
+	u.warned[id] = msg
+	log.Print(msg)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A State is the stage of an alert's lifecycle.
+type State string
+
+// Alert states. An alert is pending while its condition holds for less than
+// the alert delay, then fires. A firing alert is notified again at each
+// renotify interval until it is acknowledged or resolved. Only alerts whose
+// firing was notified are notified again when they resolve, and resolved
+// alerts are forgotten once their recovery has been notified.
+const (
+	Pending      State = "pending"
+	Firing       State = "firing"
+	Acknowledged State = "acknowledged"
+	Resolved     State = "resolved"
+)
+
+// A Condition is a problem that fmmon alerts on.
+type Condition string
+
+// Alert conditions.
+const (
+	NoGroup   Condition = "no_group"
+	FeedCount Condition = "feed_count"
+	NoData    Condition = "no_data"
+	BadData   Condition = "bad_data"
+	BelowMin  Condition = "below_min"
+	AboveMax  Condition = "above_max"
+	Stale     Condition = "stale"
+	// FishmonDown is when every feed is stale at once.
+	FishmonDown Condition = "fishmon_down"
+	ClockSkew   Condition = "clock_skew"
+
+	// FastChange, OffBaseline and WillCross are trend rules: a feed changing
+	// too quickly, straying from its usual values, and heading out of its
+	// critical range.
+	FastChange  Condition = "fast_change"
+	OffBaseline Condition = "off_baseline"
+	WillCross   Condition = "will_cross"
+)
+
+// A Severity is how urgent an alert is.
+type Severity int
+
+// Alert severities. Critical alerts ping the channel, and warnings do not.
+const (
+	Warning Severity = iota + 1
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Severity) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "warning":
+		*s = Warning
+	case "critical":
+		*s = Critical
+	default:
+		return errors.Errorf("unknown severity %q", text)
+	}
+	return nil
+}
+
+// A Problem describes a condition that currently holds.
+type Problem struct {
+	Message  string
+	Severity Severity
+}
+
+// Alert errors.
+var (
+	ErrNoSuchAlert = errors.New("no such alert")
+	ErrNotFiring   = errors.New("alert is not firing")
+	ErrBadToken    = errors.New("missing or incorrect token")
+)
+
+// An AlertKey identifies an alert by the feed and condition it is about.
+// Conditions about the whole group have an empty feed.
+type AlertKey struct {
+	Feed      string
+	Condition Condition
+}
+
+func (k AlertKey) String() string {
+	if k.Feed == "" {
+		return string(k.Condition)
+	}
+	return k.Feed + "/" + string(k.Condition)
+}
+
+// MarshalText implements encoding.TextMarshaler, so that keys can be used in
+// JSON objects.
+func (k AlertKey) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *AlertKey) UnmarshalText(text []byte) error {
+	s := string(text)
+	if idx := strings.LastIndex(s, "/"); idx != -1 {
+		k.Feed, k.Condition = s[:idx], Condition(s[idx+1:])
+		return nil
+	}
+	k.Feed, k.Condition = "", Condition(s)
+	return nil
+}
+
+// An Alert is the state of a single alert.
+type Alert struct {
+	State    State    `json:"state"`
+	Message  string   `json:"message"`
+	Severity Severity `json:"severity"`
+	// Since is when the alert's condition started to hold.
+	Since time.Time `json:"since"`
+	// NotifiedAt is when the alert's current state was last notified, or
+	// zero if it has not been.
+	NotifiedAt time.Time `json:"notified_at,omitempty"`
+	// Fired is whether the alert's firing was notified, so that its
+	// resolution is worth notifying.
+	Fired bool `json:"fired,omitempty"`
+}
+
+// A Notification is an alert that is due to be sent.
+type Notification struct {
+	Key AlertKey `json:"key"`
+	Alert
+	// Repeat is whether the alert was notified before in the same state.
+	Repeat bool `json:"repeat"`
+}
+
+// Alerts tracks the state of every alert, and persists it to a file so that
+// restarting fmmon does not notify ongoing alerts again. Alerts is safe for
+// concurrent use.
+type Alerts struct {
+	// For is how long a condition must hold before its alert fires.
+	For time.Duration
+	// Renotify is how often firing alerts are notified again. If zero, they
+	// are only notified once.
+	Renotify time.Duration
+	// Path is the file that alert state is persisted to. If empty, state is
+	// not persisted.
+	Path string
+	// Token must be sent as a bearer token to acknowledge alerts over HTTP. If
+	// empty, alerts cannot be acknowledged over HTTP.
+	Token string
+
+	mu     sync.Mutex
+	alerts map[AlertKey]*Alert
+}
+
+// LoadAlerts constructs alert state, restoring it from the file at path if the
+// file exists.
+func LoadAlerts(path string, delay, renotify time.Duration) (*Alerts, error) {
+	a := &Alerts{
+		For:      delay,
+		Renotify: renotify,
+		Path:     path,
+		alerts:   make(map[AlertKey]*Alert),
+	}
+	if path == "" {
+		return a, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read alert state")
+	}
+	if err := json.Unmarshal(data, &a.alerts); err != nil {
+		return nil, errors.Wrapf(err, "could not parse alert state in %s", path)
+	}
+	// State saved before Fired was tracked marks firing as notified by
+	// NotifiedAt alone.
+	for _, alert := range a.alerts {
+		if (alert.State == Firing || alert.State == Acknowledged) && !alert.NotifiedAt.IsZero() {
+			alert.Fired = true
+		}
+	}
+	return a, nil
+}
+
+// Update advances each alert given the conditions that currently hold, mapped
+// to their problems, and returns the alerts that are due to be notified,
+// ordered by key. Alerts whose severity rises are escalated: they fire again,
+// even if they were acknowledged. Alerts are only marked as notified by
+// Notified.
+func (a *Alerts) Update(active map[AlertKey]Problem, now time.Time) []Notification {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, problem := range active {
+		alert, ok := a.alerts[key]
+		if !ok || alert.State == Resolved {
+			alert = &Alert{State: Pending, Since: now}
+			a.alerts[key] = alert
+		}
+		escalated := alert.Severity != 0 && problem.Severity > alert.Severity
+		if escalated && (alert.State == Firing || alert.State == Acknowledged) {
+			alert.State = Firing
+			alert.NotifiedAt = time.Time{}
+		}
+		alert.Message, alert.Severity = problem.Message, problem.Severity
+		if alert.State == Pending && now.Sub(alert.Since) >= a.For {
+			alert.State = Firing
+		}
+	}
+	for key, alert := range a.alerts {
+		if _, ok := active[key]; ok {
+			continue
+		}
+		switch alert.State {
+		case Pending:
+			delete(a.alerts, key)
+		case Firing, Acknowledged:
+			if !alert.Fired {
+				delete(a.alerts, key)
+				continue
+			}
+			alert.State = Resolved
+			alert.NotifiedAt = time.Time{}
+		}
+	}
+
+	var due []Notification
+	for key, alert := range a.alerts {
+		switch {
+		case alert.State != Firing && alert.State != Resolved:
+		case alert.NotifiedAt.IsZero():
+			due = append(due, Notification{Key: key, Alert: *alert})
+		case alert.State == Firing && a.Renotify > 0 && now.Sub(alert.NotifiedAt) >= a.Renotify:
+			due = append(due, Notification{Key: key, Alert: *alert, Repeat: true})
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Key.String() < due[j].Key.String() })
+	return due
+}
+
+// Notified marks notifications as sent, forgets resolved alerts, and persists
+// the alert state. Notifications that were not sent stay due, so that they
+// are notified for the first time when they are sent.
+func (a *Alerts) Notified(sent []Notification, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, n := range sent {
+		if alert := a.notified(n, now); alert != nil && alert.State == Firing {
+			alert.Fired = true
+		}
+	}
+	return a.save()
+}
+
+// notified marks a notification as notified, and returns its alert if it is
+// still being tracked. It must be called with the lock held.
+func (a *Alerts) notified(n Notification, now time.Time) *Alert {
+	alert, ok := a.alerts[n.Key]
+	if !ok || alert.State != n.State {
+		return nil
+	}
+	if alert.State == Resolved {
+		delete(a.alerts, n.Key)
+		return nil
+	}
+	alert.NotifiedAt = now
+	return alert
+}
+
+// Acknowledge stops a firing alert from being notified again until it is
+// resolved, and persists the alert state.
+func (a *Alerts) Acknowledge(key AlertKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	alert, ok := a.alerts[key]
+	if !ok {
+		return errors.Wrapf(ErrNoSuchAlert, "%s", key)
+	}
+	if alert.State != Firing {
+		return errors.Wrapf(ErrNotFiring, "%s is %s", key, alert.State)
+	}
+	alert.State = Acknowledged
+	return a.save()
+}
+
+// Save persists the alert state.
+func (a *Alerts) Save() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.save()
+}
+
+// save writes the alert state to a temporary file and renames it over the
+// state file, so that a crash cannot leave it half written. It must be called
+// with the lock held.
+func (a *Alerts) save() error {
+	if a.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.alerts, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not marshal alert state")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(a.Path), filepath.Base(a.Path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create alert state file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "could not write alert state")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "could not write alert state")
+	}
+	if err := os.Rename(tmp.Name(), a.Path); err != nil {
+		return errors.Wrap(err, "could not replace alert state file")
+	}
+	return nil
+}
+
+// ServeHTTP lists alerts on GET, and acknowledges the alert named by the
+// "alert" form value, such as "fish.shrimp-tank/above_max", on POST, given the
+// token in an "Authorization: Bearer" header.
+func (a *Alerts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, a.String())
+	case http.MethodPost:
+		if !a.authorized(r) {
+			http.Error(w, ErrBadToken.Error(), http.StatusUnauthorized)
+			return
+		}
+		var key AlertKey
+		key.UnmarshalText([]byte(r.FormValue("alert")))
+		if err := a.Acknowledge(key); err != nil {
+			switch errors.Cause(err) {
+			case ErrNoSuchAlert:
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case ErrNotFiring:
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		fmt.Fprintf(w, "acknowledged %s\n", key)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized returns whether a request carries the token for acknowledging
+// alerts.
+func (a *Alerts) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return a.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+// String lists each alert on its own line, ordered by key.
+func (a *Alerts) String() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.alerts) == 0 {
+		return "no alerts\n"
+	}
+	lines := make([]string, 0, len(a.alerts))
+	for key, alert := range a.alerts {
+		lines = append(lines, fmt.Sprintf("%s: %s %s since %s: %s", key, alert.Severity, alert.State, alert.Since.Format(time.RFC3339), alert.Message))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A poll is one round of alerting: the problems that hold, what is done with
+// the notifications that are due, and the notifications expected.
+type poll struct {
+	after time.Duration
+	hot   Severity
+	// held is whether due notifications are held rather than sent.
+	held bool
+	// ack is whether the alert is acknowledged after the poll.
+	ack  bool
+	want []string
+}
+
+var hot = AlertKey{Feed: "fish.shrimp-tank", Condition: AboveMax}
+
+func TestAlerts(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		delay time.Duration
+		polls []poll
+	}{
+		{
+			name:  "fires and resolves",
+			polls: []poll{{hot: Critical, want: []string{"firing"}}, {hot: Critical}, {want: []string{"resolved"}}, {}},
+		},
+		{
+			name:  "fires after delay",
+			delay: 10 * time.Minute,
+			polls: []poll{
+				{hot: Warning},
+				{after: 5 * time.Minute, hot: Warning},
+				{after: 5 * time.Minute, hot: Warning, want: []string{"firing"}},
+				{after: 5 * time.Minute, hot: Warning},
+			},
+		},
+		{
+			name:  "resolves while pending",
+			delay: 10 * time.Minute,
+			polls: []poll{{hot: Warning}, {after: 5 * time.Minute}, {after: 5 * time.Minute}},
+		},
+		{
+			name:  "resolves before it is sent",
+			polls: []poll{{hot: Critical, held: true, want: []string{"firing"}}, {}},
+		},
+		{
+			name: "released",
+			polls: []poll{
+				{hot: Critical, held: true, want: []string{"firing"}},
+				{after: 2 * time.Hour, hot: Critical, held: true, want: []string{"firing"}},
+				{hot: Critical, want: []string{"firing"}},
+				{hot: Critical},
+			},
+		},
+		{
+			name: "renotifies",
+			polls: []poll{
+				{hot: Warning, want: []string{"firing"}},
+				{after: 30 * time.Minute, hot: Warning},
+				{after: 30 * time.Minute, hot: Warning, want: []string{"firing again"}},
+			},
+		},
+		{
+			name: "acknowledged",
+			polls: []poll{
+				{hot: Warning, ack: true, want: []string{"firing"}},
+				{after: 2 * time.Hour, hot: Warning},
+				{want: []string{"resolved"}},
+			},
+		},
+		{
+			name: "escalates",
+			polls: []poll{
+				{hot: Warning, ack: true, want: []string{"firing"}},
+				{hot: Critical, want: []string{"firing"}},
+				{hot: Warning},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := LoadAlerts("", test.delay, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := epoch
+			for i, p := range test.polls {
+				now = now.Add(p.after)
+				active := make(map[AlertKey]Problem)
+				if p.hot != 0 {
+					active[hot] = Problem{Message: "too hot", Severity: p.hot}
+				}
+				due := a.Update(active, now)
+				var got []string
+				for _, n := range due {
+					s := string(n.State)
+					if n.Repeat {
+						s += " again"
+					}
+					got = append(got, s)
+				}
+				if !reflect.DeepEqual(got, p.want) {
+					t.Errorf("poll %d: got notifications %q, want %q", i, got, p.want)
+				}
+				if p.held {
+					due = nil
+				}
+				if err := a.Notified(due, now); err != nil {
+					t.Fatal(err)
+				}
+				if p.ack {
+					if err := a.Acknowledge(hot); err != nil {
+						t.Fatalf("poll %d: could not acknowledge: %s", i, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAlertsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fmmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	a, err := LoadAlerts(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := map[AlertKey]Problem{hot: {Message: "too hot", Severity: Critical}}
+	if err := a.Notified(a.Update(active, epoch), epoch); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted fmmon does not notify the alert again, but does notify its
+	// resolution.
+	a, err = LoadAlerts(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := a.Update(active, epoch.Add(time.Minute)); len(due) != 0 {
+		t.Errorf("got %d notifications after restarting, want none", len(due))
+	}
+	if due := a.Update(nil, epoch.Add(2*time.Minute)); len(due) != 1 || due[0].State != Resolved {
+		t.Errorf("got notifications %+v, want the alert resolved", due)
+	}
+}
+
+func TestAlertsServeHTTP(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		token  string
+		header string
+		alert  string
+		status int
+	}{
+		{name: "no token configured", alert: hot.String(), status: http.StatusUnauthorized},
+		{name: "missing token", token: "secret", alert: hot.String(), status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer guess", alert: hot.String(), status: http.StatusUnauthorized},
+		{name: "acknowledged", token: "secret", header: "Bearer secret", alert: hot.String(), status: http.StatusOK},
+		{name: "no such alert", token: "secret", header: "Bearer secret", alert: "fish.left-tank/above_max", status: http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := LoadAlerts("", 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.Token = test.token
+			a.Update(map[AlertKey]Problem{hot: {Message: "too hot", Severity: Critical}}, epoch)
+
+			req := httptest.NewRequest(http.MethodPost, "/alerts?alert="+test.alert, nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			w := httptest.NewRecorder()
+			a.ServeHTTP(w, req)
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, test.status, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// discordMaxLength is the longest message content that Discord accepts.
+const discordMaxLength = 2000
+
+// Discord posts reports to a Discord webhook. Urgent reports mention
+// @everyone.
+type Discord struct {
+	URL string
+}
+
+type discordMessage struct {
+	Content         string                 `json:"content"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// Notify implements Notifier.
+func (d *Discord) Notify(r Report) error {
+	m := discordMessage{
+		Content: truncate(d.Format(r), discordMaxLength),
+		// Only allow the mention that Format adds, so that feed names cannot
+		// ping anyone.
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	}
+	if r.Urgent() {
+		m.AllowedMentions.Parse = []string{"everyone"}
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal Discord message")
+	}
+	if err := post(http.MethodPost, d.URL, "application/json", nil, body); err != nil {
+		return errors.Wrap(err, "could not post Discord message")
+	}
+	return nil
+}
+
+// Format renders a report in Discord's markdown. Webhooks do not expand emoji
+// shortcodes, so emoji are written out.
+func (d *Discord) Format(r Report) string {
+	var lines []string
+	if r.Urgent() {
+		lines = append(lines, "🚨 @everyone")
+	}
+	for _, n := range r.Alerts {
+		icon := "⚠️"
+		if n.Severity == Critical {
+			icon = "🚨"
+		}
+		switch {
+		case n.State == Resolved:
+			lines = append(lines, fmt.Sprintf("✅ **Resolved:** %s", n.Message))
+		case n.Repeat:
+			lines = append(lines, fmt.Sprintf("%s **Still firing** since <t:%d:R>: %s", icon, n.Since.Unix(), n.Message))
+		default:
+			lines = append(lines, fmt.Sprintf("%s %s", icon, n.Message))
+		}
+	}
+	for _, t := range r.Temperatures {
+		lines = append(lines, "🌡️ "+t.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Email sends reports by email through an SMTP server. Urgent reports are
+// marked as high priority.
+type Email struct {
+	// Addr is the SMTP server's address, such as "smtp.example.com:587".
+	Addr string
+	// Username and Password authenticate with the server if Username is set.
+	// net/smtp refuses to send them over an unencrypted connection, except to
+	// localhost.
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Notify implements Notifier.
+func (e *Email) Notify(r Report) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, err := net.SplitHostPort(e.Addr)
+		if err != nil {
+			return errors.Wrapf(err, "invalid SMTP server address %q", e.Addr)
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	msg, err := e.Format(r, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(e.Addr, auth, e.From, e.To, msg); err != nil {
+		return errors.Wrap(err, "could not send email")
+	}
+	return nil
+}
+
+// Format renders a report as a plain text email message.
+func (e *Email) Format(r Report, now time.Time) ([]byte, error) {
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", e.From},
+		{"To", strings.Join(e.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", r.Title())},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "quoted-printable"},
+	}
+	if r.Urgent() {
+		headers = append(headers, [2]string{"X-Priority", "1"}, [2]string{"Importance", "high"})
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&msg)
+	if _, err := w.Write([]byte(strings.Replace(r.Text(), "\n", "\r\n", -1) + "\r\n")); err != nil {
+		return nil, errors.Wrap(err, "could not encode email")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not encode email")
+	}
+	return msg.Bytes(), nil
+}
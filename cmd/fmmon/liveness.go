@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+)
+
+// MaxAges are per-feed maximum ages, keyed by feed key. As a flag, they are
+// written as comma-separated FEED_KEY=DURATION pairs, such as
+// "fish.left-tank=30m,fish.shrimp-tank=1h".
+type MaxAges map[string]time.Duration
+
+func (m MaxAges) String() string {
+	pairs := make([]string, 0, len(m))
+	for feed, age := range m {
+		pairs = append(pairs, feed+"="+age.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set implements flag.Value.
+func (m MaxAges) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+		idx := strings.Index(pair, "=")
+		if idx == -1 {
+			return errors.Errorf("%q is not of the form FEED_KEY=DURATION", pair)
+		}
+		age, err := time.ParseDuration(pair[idx+1:])
+		if err != nil {
+			return errors.Wrapf(err, "invalid max age for feed %s", pair[:idx])
+		}
+		m[pair[:idx]] = age
+	}
+	return nil
+}
+
+// Get returns the maximum age of a feed, or def if the feed has none set.
+func (m MaxAges) Get(feed string, def time.Duration) time.Duration {
+	if age, ok := m[feed]; ok {
+		return age
+	}
+	return def
+}
+
+// Heartbeat returns when Adafruit.IO last received a point for a feed, by its
+// own clock, from the feed's latest point. Points have no receipt time if ok is
+// false or the server does not report one, and the feed's last update time
+// is used instead, which is set by the uploader's clock. A zero time means the
+// feed has never been updated.
+func Heartbeat(feed adafruitio.Feed, latest adafruitio.Point, ok bool) time.Time {
+	if ok && !latest.UpdatedAt.IsZero() {
+		return latest.UpdatedAt
+	}
+	return feed.LastUpdated
+}
+
+// Skew estimates how far the uploader's clock is ahead of Adafruit.IO's from a
+// point: the difference between the time the uploader stamped it with and the
+// time Adafruit.IO received it. Uploads that were delayed, such as readings
+// queued during an outage, look like the uploader's clock is behind, so skew
+// should be estimated from the newest point.
+func Skew(latest adafruitio.Point) time.Duration {
+	return latest.CreatedAt.Sub(latest.UpdatedAt)
+}
+
+// Skewed returns whether a skew shows that the uploader's clock is off: ahead
+// by more than maxSkew, or behind by more than maxSkew plus maxDelay, the
+// longest that uploads are expected to be delayed after readings are taken.
+func Skewed(skew, maxSkew, maxDelay time.Duration) bool {
+	return skew > maxSkew || skew < -(maxSkew+maxDelay)
+}
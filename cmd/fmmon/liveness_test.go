@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+)
+
+func TestSkewed(t *testing.T) {
+	const (
+		maxSkew  = 2 * time.Minute
+		maxDelay = 15 * time.Minute
+	)
+	for _, test := range []struct {
+		name string
+		// created is how long after it was received a point is stamped.
+		created time.Duration
+		skewed  bool
+	}{
+		{name: "in sync"},
+		{name: "slightly ahead", created: time.Minute},
+		{name: "ahead", created: 3 * time.Minute, skewed: true},
+		{name: "slightly behind", created: -time.Minute},
+		{name: "uploaded late", created: -10 * time.Minute},
+		{name: "behind", created: -20 * time.Minute, skewed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			point := adafruitio.Point{CreatedAt: epoch.Add(test.created), UpdatedAt: epoch}
+			skew := Skew(point)
+			if skew != test.created {
+				t.Errorf("got skew %s, want %s", skew, test.created)
+			}
+			if got := Skewed(skew, maxSkew, maxDelay); got != test.skewed {
+				t.Errorf("got skewed %t, want %t", got, test.skewed)
+			}
+		})
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	const def = 10 * time.Minute
+	flags := MaxAges{"fish.left-tank": time.Hour}
+	for _, test := range []struct {
+		name     string
+		feed     string
+		settings config.MonitorFeed
+		want     time.Duration
+	}{
+		{name: "default", feed: "fish.shrimp-tank", want: def},
+		{name: "configured", feed: "fish.shrimp-tank", settings: config.MonitorFeed{MaxAge: config.Duration(30 * time.Minute)}, want: 30 * time.Minute},
+		{name: "flag", feed: "fish.left-tank", want: time.Hour},
+		{name: "flag over configured", feed: "fish.left-tank", settings: config.MonitorFeed{MaxAge: config.Duration(30 * time.Minute)}, want: time.Hour},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := flags.Get(test.feed, maxAgeOf(test.settings, def)); got != test.want {
+				t.Errorf("got max age %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -4,13 +4,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
+	"math"
+	"net/http"
 	"os"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/goodbuns/fishmon/config"
 	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/sensor"
 )
 
 type Sample struct {
@@ -19,56 +23,118 @@ type Sample struct {
 
 	CouldNotRetrieveData map[adafruitio.FeedID]bool
 	CouldNotParseData    map[adafruitio.FeedID]bool
-	BelowMinTemp         map[adafruitio.FeedID]bool
-	AboveMaxTemp         map[adafruitio.FeedID]bool
-	Stale                map[adafruitio.FeedID]bool
+	// Excursions holds the most severe out of range temperature of each
+	// feed that had one.
+	Excursions map[adafruitio.FeedID]Excursion
+	// Rates, Deviations and Projections hold the feeds that break their
+	// trend rules.
+	Rates       map[adafruitio.FeedID]Rate
+	Deviations  map[adafruitio.FeedID]Deviation
+	Projections map[adafruitio.FeedID]Projection
+	// Stale maps feeds that have not been updated within their maximum age
+	// to how long ago they were last updated. A zero age means never.
+	Stale map[adafruitio.FeedID]time.Duration
+	// Down is whether every feed is stale, which means fishmon itself has
+	// stopped rather than a single probe.
+	Down bool
+	// Skew is how far the uploader's clock is ahead of Adafruit.IO's, if by
+	// more than the maximum skew.
+	Skew time.Duration
 
-	Feeds map[adafruitio.FeedID]adafruitio.Feed
+	Feeds    map[adafruitio.FeedID]adafruitio.Feed
+	Settings map[adafruitio.FeedID]config.MonitorFeed
 }
 
-func (s *Sample) String() string {
-	var alarms []string
+// Observe records a feed's temperature, keeping the most severe excursion.
+func (s *Sample) Observe(id adafruitio.FeedID, value float64) {
+	e, ok := Classify(s.Settings[id], value)
+	if ok && e.Severity > s.Excursions[id].Severity {
+		s.Excursions[id] = e
+	}
+}
 
+// Conditions returns the alert conditions that hold in the sample, mapped to
+// their problems.
+func (s *Sample) Conditions() map[AlertKey]Problem {
+	conditions := make(map[AlertKey]Problem)
 	if s.ActualNumFeeds != s.ExpectedNumFeeds {
-		alarms = append(alarms, fmt.Sprintf(":alarm: Expected %d feeds, but found %d instead", s.ExpectedNumFeeds, s.ActualNumFeeds))
+		conditions[AlertKey{Condition: FeedCount}] = Problem{fmt.Sprintf("Expected %d feeds, but found %d instead", s.ExpectedNumFeeds, s.ActualNumFeeds), Critical}
 	}
 	for id := range s.CouldNotRetrieveData {
 		feed := s.Feeds[id]
-		alarms = append(alarms, fmt.Sprintf(":alarm: Could not retrieve data for feed %s (%s)", feed.Key, feed.Name))
+		conditions[AlertKey{feed.Key, NoData}] = Problem{fmt.Sprintf("Could not retrieve data for feed %s (%s)", feed.Key, feed.Name), Critical}
 	}
 	for id := range s.CouldNotParseData {
 		feed := s.Feeds[id]
-		alarms = append(alarms, fmt.Sprintf(":alarm: Could not parse data for feed %s (%s)", feed.Key, feed.Name))
+		conditions[AlertKey{feed.Key, BadData}] = Problem{fmt.Sprintf("Could not parse data for feed %s (%s)", feed.Key, feed.Name), Critical}
 	}
-	for id := range s.BelowMinTemp {
-		feed := s.Feeds[id]
-		alarms = append(alarms, fmt.Sprintf(":alarm: :thermometer: %s is below minimum temperature", feed.Name))
+	for id, e := range s.Excursions {
+		feed, unit := s.Feeds[id], s.Settings[id].UnitOrDefault()
+		if e.Below {
+			conditions[AlertKey{feed.Key, BelowMin}] = Problem{fmt.Sprintf("%s is below its %s minimum: %.1f%s < %.1f%s", feed.Name, e.Severity, e.Value, unit, e.Limit, unit), e.Severity}
+		} else {
+			conditions[AlertKey{feed.Key, AboveMax}] = Problem{fmt.Sprintf("%s is above its %s maximum: %.1f%s > %.1f%s", feed.Name, e.Severity, e.Value, unit, e.Limit, unit), e.Severity}
+		}
 	}
-	for id := range s.AboveMaxTemp {
-		feed := s.Feeds[id]
-		alarms = append(alarms, fmt.Sprintf(":alarm: :thermometer: %s is above maximum temperature", feed.Name))
+	for id, r := range s.Rates {
+		feed, unit := s.Feeds[id], s.Settings[id].UnitOrDefault()
+		direction := "rising"
+		if r.PerHour < 0 {
+			direction = "falling"
+		}
+		conditions[AlertKey{feed.Key, FastChange}] = Problem{fmt.Sprintf("%s is %s %.1f%s/h over the last %s, faster than its %s limit of %.1f%s/h", feed.Name, direction, math.Abs(r.PerHour), unit, span(r.Window), r.Severity, r.Limit, unit), r.Severity}
 	}
-	for id := range s.Stale {
+	for id, d := range s.Deviations {
+		feed, unit := s.Feeds[id], s.Settings[id].UnitOrDefault()
+		direction := "above"
+		if d.Value < d.Baseline {
+			direction = "below"
+		}
+		conditions[AlertKey{feed.Key, OffBaseline}] = Problem{fmt.Sprintf("%s is %.1f%s, %.1f%s %s its %s average of %.1f%s, beyond its %s limit of %.1f%s", feed.Name, d.Value, unit, math.Abs(d.Value-d.Baseline), unit, direction, span(d.Window), d.Baseline, unit, d.Severity, d.Limit, unit), d.Severity}
+	}
+	for id, p := range s.Projections {
+		feed, unit := s.Feeds[id], s.Settings[id].UnitOrDefault()
+		bound := "maximum"
+		if p.Below {
+			bound = "minimum"
+		}
+		conditions[AlertKey{feed.Key, WillCross}] = Problem{fmt.Sprintf("%s will cross its critical %s of %.1f%s in %s at %+.1f%s/h", feed.Name, bound, p.Limit, unit, approximately(p.In), p.PerHour, unit), Warning}
+	}
+	// Stale feeds are still reported when fishmon is down, so that their
+	// alerts do not resolve, but notify holds back their notifications.
+	if s.Down {
+		conditions[AlertKey{Condition: FishmonDown}] = Problem{fmt.Sprintf("fishmon is down: none of the %d feeds are updating", len(s.Feeds)), Critical}
+	}
+	for id, age := range s.Stale {
 		feed := s.Feeds[id]
-		alarms = append(alarms, fmt.Sprintf(":alarm: %s probes are not reporting", feed.Name))
+		message := fmt.Sprintf("%s probe has not reported for %s", feed.Name, age.Round(time.Second))
+		if age == 0 {
+			message = fmt.Sprintf("%s probe has never reported", feed.Name)
+		}
+		conditions[AlertKey{feed.Key, Stale}] = Problem{message, Critical}
 	}
-
-	var temperatures []string
-	for _, feed := range s.Feeds {
-		temperatures = append(temperatures, fmt.Sprintf(":thermometer: %s - %s°F", feed.Name, feed.LastValue))
+	switch {
+	case s.Skew > 0:
+		conditions[AlertKey{Condition: ClockSkew}] = Problem{fmt.Sprintf("fishmon's clock is %s ahead of Adafruit.IO's", s.Skew.Round(time.Second)), Warning}
+	case s.Skew < 0:
+		conditions[AlertKey{Condition: ClockSkew}] = Problem{fmt.Sprintf("fishmon's clock is %s behind Adafruit.IO's, or its uploads are delayed", (-s.Skew).Round(time.Second)), Warning}
 	}
-	sort.Slice(temperatures, func(i, j int) bool {
-		return temperatures[i] < temperatures[j]
-	})
+	return conditions
+}
 
-	message := strings.Join(append(alarms, temperatures...), "\n")
-	if len(alarms) == 0 {
-		message = ":heavy_check_mark: OK\n" + message
-	} else {
-		message = ":alarm: <!channel>\n" + message
+// Temperatures returns each feed's latest temperature, ordered by feed name.
+func (s *Sample) Temperatures() []Temperature {
+	var temperatures []Temperature
+	for id, feed := range s.Feeds {
+		temperatures = append(temperatures, Temperature{
+			Feed:  feed.Key,
+			Name:  feed.Name,
+			Value: feed.LastValue,
+			Unit:  s.Settings[id].UnitOrDefault(),
+		})
 	}
-
-	return message
+	sort.Slice(temperatures, func(i, j int) bool { return temperatures[i].Name < temperatures[j].Name })
+	return temperatures
 }
 
 func main() {
@@ -83,20 +149,74 @@ Usage of %s:
 		flag.PrintDefaults()
 	}
 	user := flag.String("user", "", "Adafruit.IO username")
+	aioURL := flag.String("aio_url", adafruitio.DefaultBaseURL, "Adafruit.IO API base URL")
+	aioTimeout := flag.Duration("aio_timeout", adafruitio.DefaultTimeout, "Adafruit.IO API request timeout")
 	group := flag.String("group", "fish", "Name of Adafruit.IO group feeds to monitor")
 	expectedNumFeeds := flag.Int("expected_num_feeds", 0, "Expected number of online feeds within the specified group")
-	minTemp := flag.Float64("min_temp", 65, "Lowest temperature allowed before alerting, in degrees Fahrenheit")
-	maxTemp := flag.Float64("max_temp", 83, "Highest temperature allowed before alerting, in degrees Fahrenheit")
+	configFile := flag.String("config", "", "Fmmon configuration file with per-feed temperature ranges (optional)")
+	minTemp := flag.Float64("min_temp", 65, "Lowest temperature allowed before alerting, in degrees Fahrenheit, for feeds not in the configuration file")
+	maxTemp := flag.Float64("max_temp", 83, "Highest temperature allowed before alerting, in degrees Fahrenheit, for feeds not in the configuration file")
 	pollInterval := flag.Int("poll", 5*60, "Polling interval, in seconds")
-	webhookURL := flag.String("webhook_url", "", "Webhook URL")
+	maxAge := flag.Duration("max_age", 10*time.Minute, "How long a feed can go without updates before it is stale")
+	feedMaxAges := make(MaxAges)
+	flag.Var(feedMaxAges, "feed_max_age", "Per-feed maximum ages overriding -max_age and the configuration file, as comma-separated FEED_KEY=DURATION pairs")
+	maxSkew := flag.Duration("max_skew", 2*time.Minute, "Largest difference allowed between the clocks of fishmon and Adafruit.IO")
+	maxUploadDelay := flag.Duration("max_upload_delay", 15*time.Minute, "Longest expected delay between fishmon taking a reading and uploading it, allowed on top of -max_skew when fishmon's clock looks behind")
+	webhookURL := flag.String("webhook_url", "", "Slack-compatible webhook URL, overriding the Slack notifier in the configuration file")
+	alertFor := flag.Duration("alert_for", 0, "How long a problem must last before alerting")
+	renotify := flag.Duration("renotify", time.Hour, "How often to alert again about unacknowledged problems (0 to alert once)")
+	stateFile := flag.String("state_file", "fmmon-state.json", "File to persist alert state in, so restarts do not alert again (leave empty to disable)")
+	httpAddr := flag.String("http_addr", "", "Address to serve alerts on, such as 127.0.0.1:9102, where they can be listed with GET /alerts and acknowledged with POST /alerts?alert=KEY (leave empty to disable)")
+	httpToken := flag.String("http_token", "", "Bearer token required to acknowledge alerts over HTTP (leave empty to disallow acknowledging)")
 	flag.Parse()
 
+	client := adafruitio.NewPublic(
+		adafruitio.WithBaseURL(*aioURL),
+		adafruitio.WithTimeout(*aioTimeout),
+	)
+
+	conf := &config.Monitor{}
+	if *configFile != "" {
+		var err error
+		conf, err = config.NewMonitor(*configFile)
+		if err != nil {
+			log.Fatalf("could not parse configuration file at %s: %s", *configFile, err.Error())
+		}
+	}
+	notifier, err := NewNotifiers(conf.Notifiers, *webhookURL)
+	if err != nil {
+		log.Fatalf("could not set up notifiers: %s", err.Error())
+	}
+	defaults := config.MonitorFeed{
+		Unit:     sensor.Fahrenheit,
+		Critical: &config.Range{Min: minTemp, Max: maxTemp},
+	}
+
+	alerts, err := LoadAlerts(*stateFile, *alertFor, *renotify)
+	if err != nil {
+		log.Fatalf("could not load alert state: %s", err.Error())
+	}
+	alerts.Token = *httpToken
+	if *httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/alerts", alerts)
+		go func() {
+			log.Printf("serving alerts on %s\n", *httpAddr)
+			if err := http.ListenAndServe(*httpAddr, mux); err != nil {
+				log.Fatalf("could not serve alerts on %s: %s", *httpAddr, err.Error())
+			}
+		}()
+	}
+
 	// Monitor Adafruit feed uptime.
+	history := make(History)
 	for {
-		feeds, err := adafruitio.Group(*user, *group)
+		feeds, err := client.Group(*user, *group)
 		if err != nil {
-			Send(*webhookURL, fmt.Sprintf("Could not get feed group: %s", err.Error()))
-			time.Sleep(5 * time.Minute)
+			notify(alerts, notifier, map[AlertKey]Problem{
+				{Condition: NoGroup}: {fmt.Sprintf("Could not get feed group: %s", err.Error()), Critical},
+			}, nil)
+			time.Sleep(time.Duration(*pollInterval) * time.Second)
 			continue
 		}
 
@@ -105,44 +225,116 @@ Usage of %s:
 			ActualNumFeeds:       len(feeds),
 			CouldNotRetrieveData: make(map[adafruitio.FeedID]bool),
 			CouldNotParseData:    make(map[adafruitio.FeedID]bool),
-			BelowMinTemp:         make(map[adafruitio.FeedID]bool),
-			AboveMaxTemp:         make(map[adafruitio.FeedID]bool),
-			Stale:                make(map[adafruitio.FeedID]bool),
+			Excursions:           make(map[adafruitio.FeedID]Excursion),
+			Rates:                make(map[adafruitio.FeedID]Rate),
+			Deviations:           make(map[adafruitio.FeedID]Deviation),
+			Projections:          make(map[adafruitio.FeedID]Projection),
+			Stale:                make(map[adafruitio.FeedID]time.Duration),
 			Feeds:                make(map[adafruitio.FeedID]adafruitio.Feed),
+			Settings:             make(map[adafruitio.FeedID]config.MonitorFeed),
 		}
 
-		last := time.Now().Add(-10 * time.Minute)
+		now := time.Now()
+		last := now.Add(-time.Duration(*pollInterval) * time.Second)
+		var newest adafruitio.Point
 		for _, feed := range feeds {
 			sample.Feeds[feed.ID] = feed
+			settings := FeedSettings(conf, feed.Key, defaults)
+			sample.Settings[feed.ID] = settings
 
-			// Check for liveness.
-			if feed.LastUpdated.After(last) {
-				sample.Stale[feed.ID] = true
+			// Check for liveness, by when Adafruit.IO last received a point.
+			latest, ok, err := client.Latest(*user, feed.Key)
+			if err != nil {
+				sample.CouldNotRetrieveData[feed.ID] = true
+				continue
+			}
+			heartbeat := Heartbeat(feed, latest, ok)
+			if heartbeat.IsZero() {
+				sample.Stale[feed.ID] = 0
+			} else if age := now.Sub(heartbeat); age > feedMaxAges.Get(feed.Key, maxAgeOf(settings, *maxAge)) {
+				sample.Stale[feed.ID] = age
+			}
+			if ok && latest.CreatedAt.After(newest.CreatedAt) {
+				newest = latest
 			}
 
-			// Retrieve temperature readings.
-			points, err := adafruitio.Data(*user, feed.Key, last)
+			// Retrieve temperature readings, keeping as many as the feed's
+			// trend rules need, and only retrieving ones that are new or
+			// could have been uploaded late.
+			poll := time.Duration(*pollInterval) * time.Second
+			from := now.Add(-Lookback(settings, poll))
+			points, err := client.Data(*user, feed.Key, history.Since(feed.ID, from, *maxUploadDelay))
 			if err != nil {
 				sample.CouldNotRetrieveData[feed.ID] = true
 				continue
 			}
-
-			// Check temperature readings.
+			readings := make([]Reading, 0, len(points))
 			for _, point := range points {
 				value, err := strconv.ParseFloat(point.Value, 64)
 				if err != nil {
 					sample.CouldNotParseData[feed.ID] = true
+					continue
 				}
-				if value < *minTemp {
-					sample.BelowMinTemp[feed.ID] = true
-				}
-				if value > *maxTemp {
-					sample.AboveMaxTemp[feed.ID] = true
-				}
+				readings = append(readings, Reading{At: point.CreatedAt, Value: value})
+			}
+			history.Add(feed.ID, readings...)
+			history.Trim(feed.ID, from)
+
+			// Check temperature readings since the last poll, and trends.
+			for _, reading := range history.Window(feed.ID, last) {
+				sample.Observe(feed.ID, reading.Value)
+			}
+			sample.Analyze(feed.ID, history, now)
+		}
+		for id := range history {
+			if _, ok := sample.Feeds[id]; !ok {
+				delete(history, id)
 			}
 		}
 
-		Send(*webhookURL, sample.String())
+		sample.Down = len(feeds) > 0 && len(sample.Stale) == len(feeds)
+		if skew := Skew(newest); !newest.UpdatedAt.IsZero() && Skewed(skew, *maxSkew, *maxUploadDelay) {
+			sample.Skew = skew
+		}
+
+		notify(alerts, notifier, sample.Conditions(), sample.Temperatures())
 		time.Sleep(time.Duration(*pollInterval) * time.Second)
 	}
 }
+
+// notify advances alerts given the conditions that currently hold, and sends a
+// report of the alerts that are due, with the latest temperatures, through the
+// notifiers, after retrying reports that some of them failed to send. Nothing
+// new is sent if no alerts are due. While fishmon is down, alerts
+// about single stale feeds are held rather than sent, since the fishmon down
+// alert covers them. Held alerts stay due, and are sent once fishmon is back if
+// they still hold.
+func notify(alerts *Alerts, notifier *Notifiers, conditions map[AlertKey]Problem, temperatures []Temperature) {
+	now := time.Now()
+	_, down := conditions[AlertKey{Condition: FishmonDown}]
+	var due []Notification
+	for _, n := range alerts.Update(conditions, now) {
+		if !down || n.Key.Condition != Stale {
+			due = append(due, n)
+		}
+	}
+	if len(due) > 0 {
+		if err := notifier.Notify(Report{Alerts: due, Temperatures: temperatures}); err != nil {
+			log.Printf("could not send alerts, retrying next poll: %s\n", err.Error())
+			due = nil
+		}
+	} else {
+		notifier.Retry()
+	}
+	if err := alerts.Notified(due, now); err != nil {
+		log.Printf("could not save alert state: %s\n", err.Error())
+	}
+}
+
+// maxAgeOf returns a feed's configured maximum age, or def if it has none.
+func maxAgeOf(f config.MonitorFeed, def time.Duration) time.Duration {
+	if f.MaxAge != 0 {
+		return time.Duration(f.MaxAge)
+	}
+	return def
+}
@@ -2,30 +2,273 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/sensor"
 )
 
-type Message struct {
-	Text string `json:"text"`
+// notifyTimeout bounds each request that notifiers make.
+const notifyTimeout = 30 * time.Second
+
+// MaxBacklog is the number of reports that are kept for a notifier that is
+// failing. Older ones are dropped.
+const MaxBacklog = 20
+
+var httpClient = &http.Client{Timeout: notifyTimeout}
+
+// A Report is a single message to notify: the alerts that are due, and the
+// latest temperature of each feed. Each Notifier renders it in its own way.
+type Report struct {
+	Alerts       []Notification `json:"alerts"`
+	Temperatures []Temperature  `json:"temperatures"`
+}
+
+// A Temperature is a feed's latest value.
+type Temperature struct {
+	Feed  string      `json:"feed"`
+	Name  string      `json:"name"`
+	Value string      `json:"value"`
+	Unit  sensor.Unit `json:"unit"`
+}
+
+func (t Temperature) String() string {
+	return fmt.Sprintf("%s - %s%s", t.Name, t.Value, t.Unit)
 }
 
-func Send(url, msg string) error {
-	m := Message{
-		Text: msg,
+// Urgent returns whether any critical alert in the report is firing, so that
+// it should interrupt people rather than wait to be read.
+func (r Report) Urgent() bool {
+	for _, n := range r.Alerts {
+		if n.State == Firing && n.Severity == Critical {
+			return true
+		}
 	}
+	return false
+}
 
-	body, err := json.Marshal(m)
-	if err != nil {
-		return errors.Wrap(err, "could not marshal alert message")
+// Severity returns the highest severity of the firing alerts in the report, or
+// zero if every alert has resolved.
+func (r Report) Severity() Severity {
+	var s Severity
+	for _, n := range r.Alerts {
+		if n.State == Firing && n.Severity > s {
+			s = n.Severity
+		}
+	}
+	return s
+}
+
+// Title summarizes the report in a line of plain ASCII, such as "fmmon: 2
+// critical, 1 resolved", for notifiers that have a subject.
+func (r Report) Title() string {
+	counts := make(map[string]int)
+	for _, n := range r.Alerts {
+		if n.State == Resolved {
+			counts["resolved"]++
+		} else {
+			counts[n.Severity.String()]++
+		}
+	}
+	var parts []string
+	for _, kind := range []string{Critical.String(), Warning.String(), "resolved"} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	return "fmmon: " + strings.Join(parts, ", ")
+}
+
+// Line describes a notification in plain text.
+func (n Notification) Line() string {
+	switch {
+	case n.State == Resolved:
+		return fmt.Sprintf("Resolved: %s", n.Message)
+	case n.Repeat:
+		return fmt.Sprintf("Still %s since %s: %s", n.Severity, n.Since.Format(time.RFC822), n.Message)
+	default:
+		severity := n.Severity.String()
+		return fmt.Sprintf("%s%s: %s", strings.ToUpper(severity[:1]), severity[1:], n.Message)
+	}
+}
+
+// Text renders the report as plain text: a line for each alert, then a line
+// for each temperature.
+func (r Report) Text() string {
+	var lines []string
+	for _, n := range r.Alerts {
+		lines = append(lines, n.Line())
+	}
+	if len(r.Temperatures) > 0 {
+		lines = append(lines, "")
+	}
+	for _, t := range r.Temperatures {
+		lines = append(lines, t.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// A Notifier sends reports to people.
+type Notifier interface {
+	Notify(r Report) error
+}
+
+// Notifiers sends reports through several notifiers. Each notifier has its own
+// backlog of reports that it failed to send, which are sent again, in order,
+// before its next report and by Retry, so that a notifier that fails does not
+// miss reports that the others sent.
+type Notifiers struct {
+	notifiers []Notifier
+	backlogs  [][]Report
+}
+
+// NewNotifierList constructs notifiers that send reports through each of ns.
+func NewNotifierList(ns ...Notifier) *Notifiers {
+	return &Notifiers{notifiers: ns, backlogs: make([][]Report, len(ns))}
+}
+
+// Notify sends a report through each notifier, logging the ones that fail, and
+// keeps it in their backlogs. It only returns an error if every notifier
+// fails, in which case the report is not kept, so that the caller can send it
+// again itself. Without any notifiers, the report is logged instead.
+func (ns *Notifiers) Notify(r Report) error {
+	if len(ns.notifiers) == 0 {
+		log.Printf("%s\n%s\n", r.Title(), r.Text())
+		return nil
 	}
+	var failed int
+	var last error
+	for i := range ns.notifiers {
+		ns.backlogs[i] = append(ns.backlogs[i], r)
+		if err := ns.flush(i); err != nil {
+			failed++
+			last = err
+		}
+	}
+	if failed > 0 && failed == len(ns.notifiers) {
+		for i, backlog := range ns.backlogs {
+			ns.backlogs[i] = backlog[:len(backlog)-1]
+		}
+		return last
+	}
+	for i, backlog := range ns.backlogs {
+		if len(backlog) > MaxBacklog {
+			log.Printf("dropping %d reports that %T could not send\n", len(backlog)-MaxBacklog, ns.notifiers[i])
+			ns.backlogs[i] = backlog[len(backlog)-MaxBacklog:]
+		}
+	}
+	return nil
+}
+
+// Retry sends each notifier's backlog again.
+func (ns *Notifiers) Retry() {
+	for i := range ns.notifiers {
+		ns.flush(i)
+	}
+}
 
-	_, err = http.Post(url, "application/json", bytes.NewReader(body))
+// flush sends a notifier's backlog in order, until a report fails.
+func (ns *Notifiers) flush(i int) error {
+	n := ns.notifiers[i]
+	for len(ns.backlogs[i]) > 0 {
+		if err := n.Notify(ns.backlogs[i][0]); err != nil {
+			log.Printf("could not notify through %T, %d reports waiting: %s\n", n, len(ns.backlogs[i]), err.Error())
+			return err
+		}
+		ns.backlogs[i] = ns.backlogs[i][1:]
+	}
+	return nil
+}
+
+// NewNotifiers sets up the notifiers in a configuration file. A Slack webhook
+// URL from the command line takes precedence over the configuration file.
+func NewNotifiers(c config.Notifiers, slackURL string) (*Notifiers, error) {
+	var ns []Notifier
+	if slackURL == "" && c.Slack != nil {
+		slackURL = c.Slack.URL
+	}
+	if slackURL != "" {
+		ns = append(ns, &Slack{URL: slackURL})
+	}
+	if c.Discord != nil {
+		ns = append(ns, &Discord{URL: c.Discord.URL})
+	}
+	if c.Webhook != nil {
+		w := &Webhook{
+			URL:         c.Webhook.URL,
+			Method:      c.Webhook.Method,
+			Header:      make(http.Header),
+			ContentType: c.Webhook.ContentType,
+		}
+		for key, value := range c.Webhook.Headers {
+			w.Header.Set(key, value)
+		}
+		if c.Webhook.Template != "" {
+			t, err := ParseWebhookTemplate(c.Webhook.Template)
+			if err != nil {
+				return nil, err
+			}
+			w.Template = t
+		}
+		ns = append(ns, w)
+	}
+	if e := c.Email; e != nil {
+		ns = append(ns, &Email{Addr: e.Addr, Username: e.Username, Password: e.Password, From: e.From, To: e.To})
+	}
+	if c.Ntfy != nil {
+		ns = append(ns, &Ntfy{URL: c.Ntfy.URL, Token: c.Ntfy.Token})
+	}
+	if c.Gotify != nil {
+		ns = append(ns, &Gotify{URL: c.Gotify.URL, Token: c.Gotify.Token})
+	}
+	if p := c.Pushover; p != nil {
+		ns = append(ns, &Pushover{URL: p.URL, Token: p.Token, User: p.User})
+	}
+
+	if len(ns) == 0 {
+		log.Printf("no notifiers configured, so alerts are only logged\n")
+	}
+	return NewNotifierList(ns...), nil
+}
+
+// post sends a request body to an HTTP endpoint, and checks that the response
+// status is successful.
+func post(method, url, contentType string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
-		return errors.Wrap(err, "could not send alert message")
+		return errors.Wrap(err, "could not construct request")
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
 
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not send request")
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return errors.Errorf("request failed with status %s: %s", res.Status, bytes.TrimSpace(message))
+	}
 	return nil
 }
+
+// truncate shortens text to at most max runes, for services that limit the
+// length of messages.
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// A recorder is a notifier that records the titles of the reports it sends,
+// or fails while down.
+type recorder struct {
+	down bool
+	sent []string
+}
+
+func (r *recorder) Notify(report Report) error {
+	if r.down {
+		return errors.New("down")
+	}
+	r.sent = append(r.sent, report.Title())
+	return nil
+}
+
+func TestNotifiersRetry(t *testing.T) {
+	firing := Report{Alerts: []Notification{{Alert: Alert{State: Firing, Severity: Critical}}}}
+	resolved := Report{Alerts: []Notification{{Alert: Alert{State: Resolved, Severity: Critical}}}}
+
+	up, flaky := &recorder{}, &recorder{down: true}
+	ns := NewNotifierList(up, flaky)
+	if err := ns.Notify(firing); err != nil {
+		t.Fatalf("got error %v with a notifier up, want nil", err)
+	}
+	ns.Retry()
+	flaky.down = false
+	ns.Retry()
+	if err := ns.Notify(resolved); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fmmon: 1 critical", "fmmon: 1 resolved"}
+	for name, r := range map[string]*recorder{"up": up, "flaky": flaky} {
+		if !reflect.DeepEqual(r.sent, want) {
+			t.Errorf("%s notifier sent %q, want %q", name, r.sent, want)
+		}
+	}
+
+	// When every notifier fails, the report is left for the caller to retry.
+	up.down, flaky.down = true, true
+	if err := ns.Notify(firing); err == nil {
+		t.Error("got no error with every notifier down")
+	}
+	up.down, flaky.down = false, false
+	ns.Retry()
+	if len(up.sent) != 2 || len(flaky.sent) != 2 {
+		t.Errorf("a report that every notifier failed to send was retried")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Ntfy publishes reports to an ntfy topic. Urgent reports are sent at the
+// highest priority, and reports of only resolved alerts at a low one.
+type Ntfy struct {
+	// URL is the topic's URL, such as "https://ntfy.sh/my-fish".
+	URL string
+	// Token is an access token for protected topics, if needed.
+	Token string
+}
+
+// Notify implements Notifier.
+func (n *Ntfy) Notify(r Report) error {
+	header := make(http.Header)
+	header.Set("Title", r.Title())
+	// ntfy turns tags that name emoji into icons.
+	switch r.Severity() {
+	case Critical:
+		header.Set("Priority", "urgent")
+		header.Set("Tags", "rotating_light")
+	case Warning:
+		header.Set("Priority", "default")
+		header.Set("Tags", "warning")
+	default:
+		header.Set("Priority", "low")
+		header.Set("Tags", "white_check_mark")
+	}
+	if n.Token != "" {
+		header.Set("Authorization", "Bearer "+n.Token)
+	}
+	if err := post(http.MethodPost, n.URL, "text/plain; charset=utf-8", header, []byte(r.Text())); err != nil {
+		return errors.Wrap(err, "could not publish to ntfy")
+	}
+	return nil
+}
+
+// Gotify pushes reports to a Gotify server as an application. Urgent reports
+// are sent at a priority high enough for Gotify's Android app to sound an
+// alarm.
+type Gotify struct {
+	// URL is the server's base URL, such as "https://gotify.example.com".
+	URL string
+	// Token is the application's token.
+	Token string
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+// Gotify message priorities.
+const (
+	gotifyResolved = 2
+	gotifyWarning  = 5
+	gotifyCritical = 8
+)
+
+// Notify implements Notifier.
+func (g *Gotify) Notify(r Report) error {
+	m := gotifyMessage{Title: r.Title(), Message: r.Text(), Priority: gotifyResolved}
+	switch r.Severity() {
+	case Critical:
+		m.Priority = gotifyCritical
+	case Warning:
+		m.Priority = gotifyWarning
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal Gotify message")
+	}
+	header := make(http.Header)
+	header.Set("X-Gotify-Key", g.Token)
+	if err := post(http.MethodPost, strings.TrimSuffix(g.URL, "/")+"/message", "application/json", header, body); err != nil {
+		return errors.Wrap(err, "could not push to Gotify")
+	}
+	return nil
+}
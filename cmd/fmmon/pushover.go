@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPushoverURL is the Pushover API endpoint for sending messages.
+const DefaultPushoverURL = "https://api.pushover.net/1/messages.json"
+
+// pushoverMaxLength is the longest message that Pushover accepts.
+const pushoverMaxLength = 1024
+
+// Pushover pushes reports through the Pushover API. Urgent reports are sent at
+// high priority, which bypasses the user's quiet hours, and reports of only
+// resolved alerts at low priority, which does not make a sound.
+type Pushover struct {
+	// URL is the messages endpoint. If empty, it is DefaultPushoverURL.
+	URL string
+	// Token is the application's API token.
+	Token string
+	// User is the key of the user or group to notify.
+	User string
+}
+
+// Pushover message priorities.
+const (
+	pushoverLow    = -1
+	pushoverNormal = 0
+	pushoverHigh   = 1
+)
+
+// Notify implements Notifier.
+func (p *Pushover) Notify(r Report) error {
+	priority := pushoverLow
+	switch r.Severity() {
+	case Critical:
+		priority = pushoverHigh
+	case Warning:
+		priority = pushoverNormal
+	}
+	form := url.Values{
+		"token":    {p.Token},
+		"user":     {p.User},
+		"title":    {r.Title()},
+		"message":  {truncate(r.Text(), pushoverMaxLength)},
+		"priority": {strconv.Itoa(priority)},
+	}
+	endpoint := p.URL
+	if endpoint == "" {
+		endpoint = DefaultPushoverURL
+	}
+	if err := post(http.MethodPost, endpoint, "application/x-www-form-urlencoded", nil, []byte(form.Encode())); err != nil {
+		return errors.Wrap(err, "could not push to Pushover")
+	}
+	return nil
+}
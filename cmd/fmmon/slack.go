@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Slack posts reports to a Slack incoming webhook, or any webhook that accepts
+// Slack's message format. Urgent reports ping the channel.
+type Slack struct {
+	URL string
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// Notify implements Notifier.
+func (s *Slack) Notify(r Report) error {
+	body, err := json.Marshal(slackMessage{Text: s.Format(r)})
+	if err != nil {
+		return errors.Wrap(err, "could not marshal Slack message")
+	}
+	if err := post(http.MethodPost, s.URL, "application/json", nil, body); err != nil {
+		return errors.Wrap(err, "could not post Slack message")
+	}
+	return nil
+}
+
+// Format renders a report in Slack's message syntax.
+func (s *Slack) Format(r Report) string {
+	var lines []string
+	if r.Urgent() {
+		lines = append(lines, ":alarm: <!channel>")
+	}
+	for _, n := range r.Alerts {
+		icon := ":warning:"
+		if n.Severity == Critical {
+			icon = ":alarm:"
+		}
+		switch {
+		case n.State == Resolved:
+			lines = append(lines, fmt.Sprintf(":heavy_check_mark: Resolved: %s", n.Message))
+		case n.Repeat:
+			lines = append(lines, fmt.Sprintf("%s Still firing since %s: %s", icon, n.Since.Format(time.RFC822), n.Message))
+		default:
+			lines = append(lines, fmt.Sprintf("%s %s", icon, n.Message))
+		}
+	}
+	for _, t := range r.Temperatures {
+		lines = append(lines, ":thermometer: "+t.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// An Excursion is a feed's most severe value outside of its ranges.
+type Excursion struct {
+	Severity Severity
+	Value    float64
+	// Below is whether the value is below the range rather than above it.
+	Below bool
+	// Limit is the edge of the range that the value is past.
+	Limit float64
+}
+
+// Classify returns how severely a value is outside of a feed's ranges, and
+// false if it is within them.
+func Classify(f config.MonitorFeed, v float64) (Excursion, bool) {
+	for _, band := range []struct {
+		severity Severity
+		r        *config.Range
+	}{
+		{Critical, f.Critical},
+		{Warning, f.Warning},
+	} {
+		switch {
+		case band.r.Below(v):
+			return Excursion{Severity: band.severity, Value: v, Below: true, Limit: *band.r.Min}, true
+		case band.r.Above(v):
+			return Excursion{Severity: band.severity, Value: v, Limit: *band.r.Max}, true
+		}
+	}
+	return Excursion{}, false
+}
+
+// FeedSettings returns the settings of a feed from the configuration, with its
+// unit taken from the defaults if the feed does not set one. Feeds that set
+// neither range take both from the defaults, converted to the feed's unit, so
+// that a feed's ranges are always set together and can be checked together.
+func FeedSettings(m *config.Monitor, key string, defaults config.MonitorFeed) config.MonitorFeed {
+	f := m.Feeds[key]
+	from := defaults.UnitOrDefault()
+	if f.Unit == "" {
+		f.Unit = from
+	}
+	if f.Warning == nil && f.Critical == nil {
+		f.Warning = convertRange(defaults.Warning, from, f.Unit)
+		f.Critical = convertRange(defaults.Critical, from, f.Unit)
+	}
+	return f
+}
+
+// convertRange converts a temperature range between units. It returns nil if
+// the range is nil or cannot be converted.
+func convertRange(r *config.Range, from, to sensor.Unit) *config.Range {
+	if r == nil {
+		return nil
+	}
+	convert := func(v *float64) (*float64, bool) {
+		if v == nil {
+			return nil, true
+		}
+		converted, err := sensor.Reading{Kind: sensor.Temperature, Value: *v, Unit: from}.Convert(to)
+		if err != nil {
+			return nil, false
+		}
+		return &converted.Value, true
+	}
+	min, ok := convert(r.Min)
+	if !ok {
+		return nil
+	}
+	max, ok := convert(r.Max)
+	if !ok {
+		return nil
+	}
+	return &config.Range{Min: min, Max: max}
+}
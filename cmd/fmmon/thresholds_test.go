@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+func TestFeedSettings(t *testing.T) {
+	defaults := config.MonitorFeed{
+		Unit:     sensor.Fahrenheit,
+		Critical: &config.Range{Min: float(68), Max: float(86)},
+	}
+	m := &config.Monitor{Feeds: map[string]config.MonitorFeed{
+		"fish.celsius":  {Unit: sensor.Celsius},
+		"fish.warning":  {Warning: &config.Range{Min: float(60), Max: float(90)}},
+		"fish.critical": {Critical: &config.Range{Max: float(80)}},
+		"fish.aged":     {MaxAge: config.Duration(30 * time.Minute)},
+	}}
+	for _, test := range []struct {
+		name string
+		key  string
+		want config.MonitorFeed
+	}{
+		{
+			name: "not configured",
+			key:  "fish.missing",
+			want: defaults,
+		},
+		{
+			name: "converted",
+			key:  "fish.celsius",
+			want: config.MonitorFeed{Unit: sensor.Celsius, Critical: &config.Range{Min: float(20), Max: float(30)}},
+		},
+		{
+			// A feed with only a warning range does not take a critical
+			// range that could lie within it.
+			name: "only warning",
+			key:  "fish.warning",
+			want: config.MonitorFeed{Unit: sensor.Fahrenheit, Warning: &config.Range{Min: float(60), Max: float(90)}},
+		},
+		{
+			name: "only critical",
+			key:  "fish.critical",
+			want: config.MonitorFeed{Unit: sensor.Fahrenheit, Critical: &config.Range{Max: float(80)}},
+		},
+		{
+			name: "other settings",
+			key:  "fish.aged",
+			want: config.MonitorFeed{Unit: sensor.Fahrenheit, Critical: defaults.Critical, MaxAge: config.Duration(30 * time.Minute)},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := FeedSettings(m, test.key, defaults)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got settings %s, want %s", describe(got), describe(test.want))
+			}
+		})
+	}
+}
+
+// describe formats settings with their ranges' values rather than pointers.
+func describe(f config.MonitorFeed) string {
+	format := func(r *config.Range) string {
+		if r == nil {
+			return "none"
+		}
+		side := func(v *float64) string {
+			if v == nil {
+				return "open"
+			}
+			return strconv.FormatFloat(*v, 'g', -1, 64)
+		}
+		return side(r.Min) + " to " + side(r.Max)
+	}
+	return fmt.Sprintf("{unit %s, warning %s, critical %s, max age %s}", f.Unit, format(f.Warning), format(f.Critical), time.Duration(f.MaxAge))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+)
+
+// A Reading is a feed's value at a moment in time.
+type Reading struct {
+	At    time.Time
+	Value float64
+}
+
+// History keeps each feed's recent readings, oldest first, so that trends can
+// be computed without retrieving every point in their windows at each poll.
+type History map[adafruitio.FeedID][]Reading
+
+// Since returns when to retrieve a feed's points from, to fill its history back
+// to from: backfill before the time of its newest reading, unless that is older
+// than from. Points are re-read back to backfill, so that points uploaded late
+// with their original timestamps, such as readings queued during an outage,
+// are not missed.
+func (h History) Since(id adafruitio.FeedID, from time.Time, backfill time.Duration) time.Time {
+	rs := h[id]
+	if len(rs) > 0 {
+		if since := rs[len(rs)-1].At.Add(-backfill); since.After(from) {
+			return since
+		}
+	}
+	return from
+}
+
+// Add records readings of a feed, in any order, merging them into its history
+// in order. Readings at the same time as one already recorded are ignored,
+// since they have already been recorded.
+func (h History) Add(id adafruitio.FeedID, readings ...Reading) {
+	rs := h[id]
+	recorded := make(map[int64]bool, len(rs))
+	for _, r := range rs {
+		recorded[r.At.UnixNano()] = true
+	}
+	for _, r := range readings {
+		if !recorded[r.At.UnixNano()] {
+			recorded[r.At.UnixNano()] = true
+			rs = append(rs, r)
+		}
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].At.Before(rs[j].At) })
+	h[id] = rs
+}
+
+// Trim forgets a feed's readings from before a moment in time.
+func (h History) Trim(id adafruitio.FeedID, before time.Time) {
+	rs := h[id]
+	idx := sort.Search(len(rs), func(i int) bool { return !rs[i].At.Before(before) })
+	h[id] = append([]Reading(nil), rs[idx:]...)
+}
+
+// Window returns a feed's readings from a moment in time onwards.
+func (h History) Window(id adafruitio.FeedID, since time.Time) []Reading {
+	rs := h[id]
+	idx := sort.Search(len(rs), func(i int) bool { return !rs[i].At.Before(since) })
+	return rs[idx:]
+}
+
+// Lookback returns how far back a feed's history must go for its trend rules,
+// and at least min.
+func Lookback(f config.MonitorFeed, min time.Duration) time.Duration {
+	f = f.WithDefaults()
+	lookback := min
+	for _, window := range []config.Duration{ruleWindow(f.Rate), ruleWindow(f.Baseline), forecastWindow(f.Forecast)} {
+		if d := time.Duration(window); d > lookback {
+			lookback = d
+		}
+	}
+	return lookback
+}
+
+func ruleWindow(t *config.Tolerance) config.Duration {
+	if t == nil {
+		return 0
+	}
+	return t.Window
+}
+
+func forecastWindow(f *config.Forecast) config.Duration {
+	if f == nil {
+		return 0
+	}
+	return f.Window
+}
+
+// Fit fits a line to readings by least squares. It returns the line's slope,
+// in units per hour, and its value at the time of the last reading. It returns
+// false if there are fewer than two readings, or they were all taken at once.
+func Fit(rs []Reading) (perHour, value float64, ok bool) {
+	if len(rs) < 2 {
+		return 0, 0, false
+	}
+	// Measure time in hours before the last reading, to keep the sums small.
+	end := rs[len(rs)-1].At
+	var sx, sy, sxx, sxy float64
+	for _, r := range rs {
+		x := r.At.Sub(end).Hours()
+		sx += x
+		sy += r.Value
+		sxx += x * x
+		sxy += x * r.Value
+	}
+	n := float64(len(rs))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, 0, false
+	}
+	slope := (n*sxy - sx*sy) / den
+	return slope, (sy - slope*sx) / n, true
+}
+
+// Mean returns the mean value of readings.
+func Mean(rs []Reading) float64 {
+	var sum float64
+	for _, r := range rs {
+		sum += r.Value
+	}
+	return sum / float64(len(rs))
+}
+
+// covers returns whether readings span at least half of a window, so that the
+// few readings left after a restart or an outage do not raise alerts.
+func covers(rs []Reading, window time.Duration) bool {
+	return len(rs) >= 2 && rs[len(rs)-1].At.Sub(rs[0].At) >= window/2
+}
+
+// exceeds returns the severity of a magnitude beyond a tolerance's limits, or
+// zero if it is within them.
+func exceeds(t *config.Tolerance, v float64) (Severity, float64) {
+	switch {
+	case t.Critical != nil && v > *t.Critical:
+		return Critical, *t.Critical
+	case t.Warning != nil && v > *t.Warning:
+		return Warning, *t.Warning
+	default:
+		return 0, 0
+	}
+}
+
+// A Rate is a feed changing faster than its rate rule allows.
+type Rate struct {
+	Severity Severity
+	PerHour  float64
+	Limit    float64
+	Window   time.Duration
+}
+
+// A Deviation is a feed's latest value straying too far from its baseline.
+type Deviation struct {
+	Severity Severity
+	Value    float64
+	Baseline float64
+	Limit    float64
+	Window   time.Duration
+}
+
+// A Projection is a feed trending out of its critical range within its
+// forecast horizon.
+type Projection struct {
+	PerHour float64
+	// Below is whether the feed will fall below its range rather than rise
+	// above it.
+	Below bool
+	Limit float64
+	// In is how long until the feed crosses the limit.
+	In time.Duration
+}
+
+// Analyze checks a feed's history against its trend rules, and records the
+// rules that it breaks.
+func (s *Sample) Analyze(id adafruitio.FeedID, history History, now time.Time) {
+	f := s.Settings[id].WithDefaults()
+
+	if f.Rate != nil {
+		window := time.Duration(f.Rate.Window)
+		rs := history.Window(id, now.Add(-window))
+		if perHour, _, ok := Fit(rs); ok && covers(rs, window) {
+			if severity, limit := exceeds(f.Rate, math.Abs(perHour)); severity != 0 {
+				s.Rates[id] = Rate{Severity: severity, PerHour: perHour, Limit: limit, Window: window}
+			}
+		}
+	}
+
+	if f.Baseline != nil {
+		window := time.Duration(f.Baseline.Window)
+		rs := history.Window(id, now.Add(-window))
+		if covers(rs, window) {
+			value, baseline := rs[len(rs)-1].Value, Mean(rs)
+			if severity, limit := exceeds(f.Baseline, math.Abs(value-baseline)); severity != 0 {
+				s.Deviations[id] = Deviation{Severity: severity, Value: value, Baseline: baseline, Limit: limit, Window: window}
+			}
+		}
+	}
+
+	if f.Forecast != nil && f.Critical != nil {
+		window := time.Duration(f.Forecast.Window)
+		rs := history.Window(id, now.Add(-window))
+		perHour, value, ok := Fit(rs)
+		if !ok || !covers(rs, window) {
+			return
+		}
+		// Project from the last reading, which may be a little before now.
+		p := Projection{PerHour: perHour}
+		switch {
+		case perHour > 0 && f.Critical.Max != nil && value <= *f.Critical.Max:
+			p.Limit = *f.Critical.Max
+		case perHour < 0 && f.Critical.Min != nil && value >= *f.Critical.Min:
+			p.Below, p.Limit = true, *f.Critical.Min
+		default:
+			return
+		}
+		hours := (p.Limit - value) / perHour
+		p.In = time.Duration(hours*float64(time.Hour)) - now.Sub(rs[len(rs)-1].At)
+		if p.In >= 0 && p.In <= time.Duration(f.Forecast.Horizon) {
+			s.Projections[id] = p
+		}
+	}
+}
+
+// span formats a window of time compactly, such as "30m" or "24h".
+func span(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// approximately formats how long until something happens, such as
+// "~40 minutes".
+func approximately(d time.Duration) string {
+	switch minutes := int(d.Round(time.Minute) / time.Minute); {
+	case minutes <= 1:
+		return "~1 minute"
+	case minutes < 120:
+		return fmt.Sprintf("~%d minutes", minutes)
+	default:
+		return fmt.Sprintf("~%d hours", int(d.Round(time.Hour)/time.Hour))
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/config"
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// line returns readings every interval over a span ending at epoch, starting at
+// start and changing by perHour.
+func line(span, interval time.Duration, start, perHour float64) []Reading {
+	var rs []Reading
+	for at := -span; at <= 0; at += interval {
+		rs = append(rs, Reading{
+			At:    epoch.Add(at),
+			Value: start + perHour*(at+span).Hours(),
+		})
+	}
+	return rs
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestFit(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		readings []Reading
+		perHour  float64
+		value    float64
+		ok       bool
+	}{
+		{name: "empty"},
+		{name: "single", readings: line(0, time.Minute, 75, 0)},
+		{
+			name:     "same time",
+			readings: []Reading{{At: epoch, Value: 75}, {At: epoch, Value: 76}},
+		},
+		{
+			name:     "flat",
+			readings: line(time.Hour, 10*time.Minute, 75, 0),
+			perHour:  0, value: 75, ok: true,
+		},
+		{
+			name:     "rising",
+			readings: line(2*time.Hour, 10*time.Minute, 70, 1.5),
+			perHour:  1.5, value: 73, ok: true,
+		},
+		{
+			name:     "falling",
+			readings: line(time.Hour, 5*time.Minute, 80, -2),
+			perHour:  -2, value: 78, ok: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			perHour, value, ok := Fit(test.readings)
+			if ok != test.ok {
+				t.Fatalf("got ok %t, want %t", ok, test.ok)
+			}
+			if math.Abs(perHour-test.perHour) > 1e-9 || math.Abs(value-test.value) > 1e-9 {
+				t.Errorf("got %f/h ending at %f, want %f/h ending at %f", perHour, value, test.perHour, test.value)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	const id = adafruitio.FeedID(1)
+	rate := &config.Tolerance{Warning: float(1), Critical: float(2)}
+	for _, test := range []struct {
+		name       string
+		settings   config.MonitorFeed
+		readings   []Reading
+		rate       Severity
+		deviation  Severity
+		projection bool
+	}{
+		{
+			name:     "steady",
+			settings: config.MonitorFeed{Rate: rate, Baseline: &config.Tolerance{Critical: float(2)}},
+			readings: line(24*time.Hour, 10*time.Minute, 75, 0),
+		},
+		{
+			name:     "warming",
+			settings: config.MonitorFeed{Rate: rate},
+			readings: line(time.Hour, 5*time.Minute, 75, 1.5),
+			rate:     Warning,
+		},
+		{
+			name:     "heater stuck on",
+			settings: config.MonitorFeed{Rate: rate},
+			readings: line(time.Hour, 5*time.Minute, 75, 3),
+			rate:     Critical,
+		},
+		{
+			name:     "too little history",
+			settings: config.MonitorFeed{Rate: rate},
+			readings: line(20*time.Minute, 5*time.Minute, 75, 3),
+		},
+		{
+			name:      "off baseline",
+			settings:  config.MonitorFeed{Baseline: &config.Tolerance{Warning: float(1), Critical: float(3)}},
+			readings:  append(line(24*time.Hour, time.Hour, 75, 0)[1:], Reading{At: epoch.Add(time.Minute), Value: 73}),
+			deviation: Warning,
+		},
+		{
+			name: "will cross",
+			settings: config.MonitorFeed{
+				Critical: &config.Range{Max: float(80)},
+				Forecast: &config.Forecast{},
+			},
+			readings:   line(time.Hour, 5*time.Minute, 77, 2),
+			projection: true,
+		},
+		{
+			name: "will cross after horizon",
+			settings: config.MonitorFeed{
+				Critical: &config.Range{Max: float(90)},
+				Forecast: &config.Forecast{},
+			},
+			readings: line(time.Hour, 5*time.Minute, 77, 2),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			history := make(History)
+			history.Add(id, test.readings...)
+			s := Sample{
+				Rates:       make(map[adafruitio.FeedID]Rate),
+				Deviations:  make(map[adafruitio.FeedID]Deviation),
+				Projections: make(map[adafruitio.FeedID]Projection),
+				Settings:    map[adafruitio.FeedID]config.MonitorFeed{id: test.settings},
+			}
+			now := history[id][len(history[id])-1].At
+			s.Analyze(id, history, now)
+
+			if got := s.Rates[id].Severity; got != test.rate {
+				t.Errorf("got rate severity %s, want %s", got, test.rate)
+			}
+			if got := s.Deviations[id].Severity; got != test.deviation {
+				t.Errorf("got deviation severity %s, want %s", got, test.deviation)
+			}
+			if _, got := s.Projections[id]; got != test.projection {
+				t.Errorf("got projection %t, want %t", got, test.projection)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	id := adafruitio.FeedID(1)
+	at := func(minutes int) Reading {
+		return Reading{At: epoch.Add(time.Duration(minutes) * time.Minute), Value: float64(minutes)}
+	}
+	for _, test := range []struct {
+		name  string
+		polls [][]Reading
+		want  []Reading
+		// since is when to retrieve points from after the polls.
+		since time.Time
+	}{
+		{
+			name:  "empty",
+			since: epoch.Add(-time.Hour),
+		},
+		{
+			name:  "new readings",
+			polls: [][]Reading{{at(0), at(1)}, {at(2)}},
+			want:  []Reading{at(0), at(1), at(2)},
+			since: epoch.Add(-13 * time.Minute),
+		},
+		{
+			name:  "backfilled readings",
+			polls: [][]Reading{{at(0), at(5)}, {at(1), at(5), at(2), at(6)}},
+			want:  []Reading{at(0), at(1), at(2), at(5), at(6)},
+			since: epoch.Add(-9 * time.Minute),
+		},
+		{
+			name:  "re-read readings",
+			polls: [][]Reading{{at(0), at(1)}, {at(0), at(1)}},
+			want:  []Reading{at(0), at(1)},
+			since: epoch.Add(-14 * time.Minute),
+		},
+		{
+			name:  "backfill older than lookback",
+			polls: [][]Reading{{at(-50)}},
+			want:  []Reading{at(-50)},
+			since: epoch.Add(-time.Hour),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			history := History{}
+			for _, poll := range test.polls {
+				history.Add(id, poll...)
+			}
+			got := history.Window(id, time.Time{})
+			if len(got) != len(test.want) {
+				t.Fatalf("got readings %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if !got[i].At.Equal(test.want[i].At) || got[i].Value != test.want[i].Value {
+					t.Errorf("got reading %d %v, want %v", i, got[i], test.want[i])
+				}
+			}
+			if since := history.Since(id, epoch.Add(-time.Hour), 15*time.Minute); !since.Equal(test.since) {
+				t.Errorf("got since %s, want %s", since, test.since)
+			}
+		})
+	}
+}
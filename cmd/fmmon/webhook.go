@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Webhook sends reports to an HTTP endpoint, with a body rendered from a
+// template. Templates are executed with the Report, and can call its methods,
+// such as {{.Title}} and {{.Text}}, and the json function, which writes a
+// value as JSON: {"summary": {{json .Title}}}.
+type Webhook struct {
+	URL         string
+	Method      string
+	Header      http.Header
+	ContentType string
+	// Template renders the request body. If nil, the report is sent as JSON.
+	Template *template.Template
+}
+
+// ParseWebhookTemplate parses a webhook body template.
+func ParseWebhookTemplate(text string) (*template.Template, error) {
+	t, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse webhook template")
+	}
+	return t, nil
+}
+
+// Notify implements Notifier.
+func (w *Webhook) Notify(r Report) error {
+	var body []byte
+	if w.Template == nil {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return errors.Wrap(err, "could not marshal webhook body")
+		}
+		body = data
+	} else {
+		var buf bytes.Buffer
+		if err := w.Template.Execute(&buf, r); err != nil {
+			return errors.Wrap(err, "could not render webhook body")
+		}
+		body = buf.Bytes()
+	}
+
+	method, contentType := w.Method, w.ContentType
+	if method == "" {
+		method = http.MethodPost
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if err := post(method, w.URL, contentType, w.Header, body); err != nil {
+		return errors.Wrap(err, "could not send webhook")
+	}
+	return nil
+}
@@ -1,44 +1,385 @@
-// Package config provides configuration file parsing for mapping temperature
-// probes to Adafruit.IO feeds.
+// Package config provides configuration file parsing for fishmon: which tanks
+// and sensors are monitored, where readings are sent, how often sensors are
+// sampled and when to raise alerts. It also parses fmmon's configuration
+// file, which sets the safe range of each monitored feed and where alerts are
+// sent.
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"time"
 
 	"github.com/pkg/errors"
 
-	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/aggregate"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// Configuration file versions.
+const (
+	Version1 = "1"
+	Version2 = "2"
+	// CurrentVersion is the version that older files are migrated to.
+	CurrentVersion = Version2
 )
 
 // Configuration errors.
 var (
-	ErrNoSuchProbe = errors.New("probe configuration not found")
+	ErrNoSuchProbe      = errors.New("probe configuration not found")
+	ErrUnknownVersion   = errors.New("unknown configuration version")
+	ErrUnknownField     = errors.New("unknown field")
+	ErrRequired         = errors.New("required")
+	ErrNoSuchTank       = errors.New("tank not configured")
+	ErrNoSuchSensor     = errors.New("sensor not configured")
+	ErrUnknownKind      = errors.New("unknown sensor kind")
+	ErrUnknownUnit      = errors.New("unknown unit")
+	ErrIncompatible     = errors.New("unit does not measure sensor kind")
+	ErrNegative         = errors.New("must not be negative")
+	ErrEmptyRange       = errors.New("min must be less than max")
+	ErrAmbiguousTarget  = errors.New("set exactly one of sensor or tank")
+	ErrUnknownStatistic = errors.New("unknown statistic")
 )
 
 // File stores the contents of a configuration file.
 type File struct {
-	Version string
-	Probes  map[ds18b20.ID]Probe
+	Version  string               `json:"version"`
+	Sampling Sampling             `json:"sampling"`
+	Tanks    map[string]Tank      `json:"tanks"`
+	Sensors  map[sensor.ID]Sensor `json:"sensors"`
+	Sinks    Sinks                `json:"sinks"`
+	Alerts   []Alert              `json:"alerts"`
+	// Unconfigured sets what happens to readings from probes that are not
+	// in Sensors.
+	Unconfigured Unconfigured `json:"unconfigured"`
+}
+
+// Sampling defaults.
+const (
+	DefaultSampleInterval    = Duration(10 * time.Second)
+	DefaultAttempts          = 3
+	DefaultQuarantineAfter   = 5
+	DefaultQuarantineBackoff = Duration(time.Minute)
+	DefaultMaxBackoff        = Duration(30 * time.Minute)
+	DefaultMaxRate           = 1.0
+	DefaultMedianWindow      = 3
+)
+
+// Sampling configures how often sensors are read, and how failed reads are
+// handled.
+type Sampling struct {
+	// Interval is the time between readings of each sensor, unless the
+	// sensor sets its own. If zero, it is DefaultSampleInterval.
+	Interval Duration `json:"interval"`
+	// UploadInterval is the time between sending statistics of each sensor's
+	// readings to sinks. If zero, fishmon picks an interval that keeps within
+	// the Adafruit.IO rate limit.
+	UploadInterval Duration `json:"upload_interval"`
+	// Attempts is how many times a read that fails its CRC check is tried
+	// in each round. If zero, it is DefaultAttempts.
+	Attempts int `json:"attempts"`
+	// QuarantineAfter is how many rounds in a row a sensor must fail before
+	// it is quarantined. If zero, it is DefaultQuarantineAfter.
+	QuarantineAfter int `json:"quarantine_after"`
+	// QuarantineBackoff is how long a quarantined sensor is left alone before
+	// it is read again. The backoff doubles each time the sensor fails again,
+	// up to MaxBackoff. If zero, they are DefaultQuarantineBackoff and
+	// DefaultMaxBackoff.
+	QuarantineBackoff Duration `json:"quarantine_backoff"`
+	MaxBackoff        Duration `json:"max_backoff"`
+	// Filter configures the rejection of glitched temperature readings.
+	Filter Filter `json:"filter"`
+}
+
+// Filter configures how glitched temperature readings are rejected. The
+// sentinel values that DS18B20 probes report instead of a temperature are
+// always rejected.
+type Filter struct {
+	// MaxRate is the fastest plausible change in temperature, in °C per
+	// second. Readings that change faster are rejected, unless later
+	// readings confirm the change. If zero, it is DefaultMaxRate.
+	MaxRate float64 `json:"max_rate"`
+	// MedianWindow is the number of readings that each reading is despiked
+	// over, by taking their median. 1 disables despiking. If zero, it is
+	// DefaultMedianWindow.
+	MedianWindow int `json:"median_window"`
+}
+
+// WithDefaults returns the sampling configuration with defaults filled in.
+func (s Sampling) WithDefaults() Sampling {
+	if s.Interval == 0 {
+		s.Interval = DefaultSampleInterval
+	}
+	if s.Attempts == 0 {
+		s.Attempts = DefaultAttempts
+	}
+	if s.QuarantineAfter == 0 {
+		s.QuarantineAfter = DefaultQuarantineAfter
+	}
+	if s.QuarantineBackoff == 0 {
+		s.QuarantineBackoff = DefaultQuarantineBackoff
+	}
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = DefaultMaxBackoff
+	}
+	if s.Filter.MaxRate == 0 {
+		s.Filter.MaxRate = DefaultMaxRate
+	}
+	if s.Filter.MedianWindow == 0 {
+		s.Filter.MedianWindow = DefaultMedianWindow
+	}
+	return s
 }
 
-// Probe stores the configuration for a single temperature probe.
-type Probe struct {
-	Name    string
+// Tank stores the configuration for a single fish tank.
+type Tank struct {
+	Name string `json:"name"`
+}
+
+// Sensor stores the configuration for a single sensor, such as a temperature
+// probe.
+type Sensor struct {
+	// Name is a human-readable name for the sensor.
+	Name string `json:"name"`
+	// Tank is the key of the tank that the sensor is in, if any.
+	Tank string `json:"tank"`
+	// Kind is the quantity that the sensor measures. If empty, it is
+	// temperature.
+	Kind sensor.Kind `json:"kind"`
+	// FeedKey is the Adafruit.IO feed key that the mean of the sensor's
+	// readings between uploads is sent to.
 	FeedKey string `json:"feed"`
+	// Feeds maps other statistics of the sensor's readings between uploads,
+	// such as their minimum and maximum, to the feeds they are sent to.
+	Feeds map[aggregate.Statistic]string `json:"feeds"`
+	// Interval is the time between readings of the sensor. If zero, it is
+	// the sampling interval.
+	Interval Duration `json:"interval"`
+	// Resolution is the resolution of a DS18B20 probe, from 9 to 12 bits.
+	// Each bit less halves the time the probe takes to read, and doubles the
+	// smallest change it can measure. If zero, the probe's resolution is
+	// left as it is.
+	Resolution int `json:"resolution"`
+	// Alarms are on-chip alarm thresholds to write to a DS18B20 probe. If
+	// nil, the probe's thresholds are left as they are.
+	Alarms *Thresholds `json:"alarms"`
+}
+
+// Thresholds are the low and high alarm thresholds of a DS18B20 probe, in whole
+// degrees Celsius.
+type Thresholds struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+}
+
+// StatisticFeeds returns the feed that each statistic of the sensor's readings
+// is sent to, including the mean.
+func (s Sensor) StatisticFeeds() map[aggregate.Statistic]string {
+	feeds := make(map[aggregate.Statistic]string, len(s.Feeds)+1)
+	for stat, feed := range s.Feeds {
+		feeds[stat] = feed
+	}
+	if s.FeedKey != "" {
+		feeds[aggregate.Mean] = s.FeedKey
+	}
+	return feeds
+}
+
+// An UnconfiguredPolicy says what to do with readings from unconfigured
+// probes.
+type UnconfiguredPolicy string
+
+// Unconfigured probe policies.
+const (
+	// Skip warns about unconfigured probes and drops their readings.
+	Skip UnconfiguredPolicy = "skip"
+	// CreateFeed creates an Adafruit.IO feed named after the probe's ID, and
+	// sends readings to every sink.
+	CreateFeed UnconfiguredPolicy = "create_feed"
+	// LogOnly logs readings locally without sending them to any sink.
+	LogOnly UnconfiguredPolicy = "log"
+)
+
+// Unconfigured configures the handling of probes that are attached but not
+// configured.
+type Unconfigured struct {
+	// Policy is the handling policy. If empty, it is Skip.
+	Policy UnconfiguredPolicy `json:"policy"`
+	// Group is the key of the Adafruit.IO group that CreateFeed creates feeds
+	// in.
+	Group string `json:"group"`
+}
+
+// PolicyOrDefault returns the handling policy, defaulting to Skip.
+func (u Unconfigured) PolicyOrDefault() UnconfiguredPolicy {
+	if u.Policy == "" {
+		return Skip
+	}
+	return u.Policy
+}
+
+// SampleInterval returns the time between readings of a sensor, which need not
+// be configured.
+func (f *File) SampleInterval(id sensor.ID) time.Duration {
+	if s, ok := f.Sensors[id]; ok && s.Interval != 0 {
+		return time.Duration(s.Interval)
+	}
+	return time.Duration(f.Sampling.WithDefaults().Interval)
+}
+
+// Sinks configures the destinations for readings. A nil sink is disabled.
+type Sinks struct {
+	AdafruitIO  *AdafruitIO  `json:"adafruitio"`
+	File        *FileSink    `json:"file"`
+	InfluxDB    *InfluxDB    `json:"influxdb"`
+	Pushgateway *Pushgateway `json:"pushgateway"`
+	MQTT        *MQTT        `json:"mqtt"`
+}
+
+// AdafruitIO configures uploads to Adafruit.IO. Empty fields use the defaults
+// of the corresponding fishmon flags.
+type AdafruitIO struct {
+	Username        string          `json:"username"`
+	Key             string          `json:"key"`
+	URL             string          `json:"url"`
+	Timeout         Duration        `json:"timeout"`
+	Tier            adafruitio.Tier `json:"tier"`
+	Transport       string          `json:"transport"`
+	MQTTAddr        string          `json:"mqtt_addr"`
+	MQTTTLS         *bool           `json:"mqtt_tls"`
+	QueueDir        string          `json:"queue_dir"`
+	TemperatureUnit sensor.Unit     `json:"temperature_unit"`
+}
+
+// FileSink configures appending readings to a local file.
+type FileSink struct {
+	Path string `json:"path"`
+}
+
+// InfluxDB configures writing readings to InfluxDB.
+type InfluxDB struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
 }
 
-// New parses a configuration file.
+// Pushgateway configures pushing readings to a Prometheus Pushgateway.
+type Pushgateway struct {
+	URL string `json:"url"`
+}
+
+// MQTT configures publishing readings to an MQTT server.
+type MQTT struct {
+	Addr     string `json:"addr"`
+	TLS      bool   `json:"tls"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	ClientID string `json:"client_id"`
+	Topic    string `json:"topic"`
+}
+
+// Alert is a rule that fires when readings of a sensor, or of every sensor in
+// a tank, stay outside of a range.
+type Alert struct {
+	Name   string    `json:"name"`
+	Sensor sensor.ID `json:"sensor"`
+	Tank   string    `json:"tank"`
+	// Min and Max bound the acceptable range of readings, in Unit. Either
+	// may be nil to leave that side of the range open.
+	Min  *float64    `json:"min"`
+	Max  *float64    `json:"max"`
+	Unit sensor.Unit `json:"unit"`
+	// For is how long readings must stay out of range before the alert fires.
+	For Duration `json:"for"`
+}
+
+// Duration is a time.Duration that is written in JSON as a string, such as
+// "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("duration must be a string such as \"1m30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// New parses a configuration file, migrating it to the current version and
+// validating it.
 func New(filename string) (*File, error) {
-	bytes, err := ioutil.ReadFile(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not read fishmon configuration file")
 	}
-	var file File
-	err = json.Unmarshal(bytes, &file)
+	file, err := Parse(data)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not unmarshal fishmon configuration file")
+		return nil, errors.Wrap(err, "invalid fishmon configuration file")
+	}
+	return file, nil
+}
+
+// Parse parses the contents of a configuration file of any version, migrating
+// it to the current version and validating it. Unknown fields are rejected.
+func Parse(data []byte) (*File, error) {
+	var header struct {
+		Version string
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal configuration")
 	}
 
-	return &file, nil
+	var file *File
+	switch header.Version {
+	case Version1:
+		var v1 FileV1
+		if err := decodeStrict(data, &v1); err != nil {
+			return nil, err
+		}
+		file = v1.Migrate()
+	case Version2:
+		file = &File{}
+		if err := decodeStrict(data, file); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &FieldError{Path: "version", Err: errors.Wrapf(ErrUnknownVersion, "%q", header.Version)}
+	}
+
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// decodeStrict unmarshals data into v, rejecting fields that v does not have.
+func decodeStrict(data []byte, v interface{}) error {
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return errors.Wrap(err, "could not unmarshal configuration")
+	}
+	if err := checkFields("", raw, reflectType(v)); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return &FieldError{Path: typeErr.Field, Err: errors.Errorf("cannot use %s as %s", typeErr.Value, typeErr.Type)}
+		}
+		return errors.Wrap(err, "could not unmarshal configuration")
+	}
+	return nil
 }
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// reflectType returns the type that a pointer passed to json.Unmarshal points
+// to.
+func reflectType(v interface{}) reflect.Type {
+	return reflect.TypeOf(v).Elem()
+}
+
+// checkFields checks that a decoded JSON value can be unmarshalled into type t
+// without ignoring any object fields, reporting problems at their JSON path.
+func checkFields(path string, raw interface{}, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if raw == nil {
+		return nil
+	}
+
+	// Let custom types check their own values.
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return &FieldError{Path: path, Err: err}
+		}
+		if err := reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(data); err != nil {
+			return &FieldError{Path: path, Err: err}
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return typeError(path, raw, "an object")
+		}
+		for key, value := range obj {
+			field, ok := fieldByJSONName(t, key)
+			if !ok {
+				return &FieldError{Path: joinPath(path, key), Err: ErrUnknownField}
+			}
+			if err := checkFields(joinPath(path, key), value, field.Type); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return typeError(path, raw, "an object")
+		}
+		for key, value := range obj {
+			if err := checkFields(indexPath(path, key), value, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		arr, ok := raw.([]interface{})
+		if !ok {
+			return typeError(path, raw, "an array")
+		}
+		for i, value := range arr {
+			if err := checkFields(indexPath(path, i), value, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if _, ok := raw.(string); !ok {
+			return typeError(path, raw, "a string")
+		}
+	case reflect.Bool:
+		if _, ok := raw.(bool); !ok {
+			return typeError(path, raw, "a boolean")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := raw.(json.Number); !ok {
+			return typeError(path, raw, "a number")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := raw.(json.Number)
+		if !ok {
+			return typeError(path, raw, "a number")
+		}
+		if _, err := n.Int64(); err != nil {
+			return typeError(path, raw, "an integer")
+		}
+	}
+	return nil
+}
+
+// fieldByJSONName finds the struct field that encoding/json would unmarshal
+// an object key into.
+func fieldByJSONName(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func typeError(path string, raw interface{}, want string) error {
+	var got string
+	switch raw.(type) {
+	case map[string]interface{}:
+		got = "an object"
+	case []interface{}:
+		got = "an array"
+	case string:
+		got = "a string"
+	case bool:
+		got = "a boolean"
+	case json.Number:
+		got = "a number"
+	}
+	return &FieldError{Path: path, Err: errors.Errorf("must be %s, not %s", want, got)}
+}
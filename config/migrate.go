@@ -0,0 +1,37 @@
+package config
+
+import (
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// FileV1 stores the contents of a version 1 configuration file, which only maps
+// temperature probes to Adafruit.IO feeds.
+type FileV1 struct {
+	Version string
+	Probes  map[ds18b20.ID]Probe
+}
+
+// Probe stores the version 1 configuration for a single temperature probe.
+type Probe struct {
+	Name    string
+	FeedKey string `json:"feed"`
+}
+
+// Migrate converts a version 1 configuration to the current version. Each
+// probe becomes a temperature sensor. Settings that version 1 files could not
+// hold are left empty, so fishmon's flags and defaults apply.
+func (f *FileV1) Migrate() *File {
+	file := &File{
+		Version: CurrentVersion,
+		Sensors: make(map[sensor.ID]Sensor, len(f.Probes)),
+	}
+	for id, p := range f.Probes {
+		file.Sensors[id] = Sensor{
+			Name:    p.Name,
+			Kind:    sensor.Temperature,
+			FeedKey: p.FeedKey,
+		}
+	}
+	return file
+}
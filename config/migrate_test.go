@@ -0,0 +1,92 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+func TestParseMigrates(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		want *File
+		err  error
+	}{
+		{
+			name: "version 1",
+			data: `{
+				"version": "1",
+				"probes": {
+					"28-02089245bf26": {"name": "left tank", "feed": "fish.left-tank"},
+					"28-020b924565c7": {"name": "shrimp tank", "feed": "fish.shrimp-tank"}
+				}
+			}`,
+			want: &File{
+				Version: CurrentVersion,
+				Sensors: map[sensor.ID]Sensor{
+					"28-02089245bf26": {Name: "left tank", Kind: sensor.Temperature, FeedKey: "fish.left-tank"},
+					"28-020b924565c7": {Name: "shrimp tank", Kind: sensor.Temperature, FeedKey: "fish.shrimp-tank"},
+				},
+			},
+		},
+		{
+			name: "version 1 without probes",
+			data: `{"version": "1", "probes": {}}`,
+			want: &File{Version: CurrentVersion, Sensors: map[sensor.ID]Sensor{}},
+		},
+		{
+			name: "version 2 is not migrated",
+			data: `{
+				"version": "2",
+				"sensors": {
+					"28-02089245bf26": {"name": "left tank", "kind": "temperature", "feed": "fish.left-tank"}
+				}
+			}`,
+			want: &File{
+				Version: Version2,
+				Sensors: map[sensor.ID]Sensor{
+					"28-02089245bf26": {Name: "left tank", Kind: sensor.Temperature, FeedKey: "fish.left-tank"},
+				},
+			},
+		},
+		{
+			name: "version 1 with unknown fields",
+			data: `{"version": "1", "probes": {}, "sensors": {}}`,
+			err:  ErrUnknownField,
+		},
+		{
+			name: "unknown version",
+			data: `{"version": "3"}`,
+			err:  ErrUnknownVersion,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse([]byte(test.data))
+			if test.err != nil {
+				if !isError(err, test.err) {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not parse: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// isError returns whether err is target, or a field error or wrapped error
+// caused by it.
+func isError(err, target error) bool {
+	if fe, ok := err.(*FieldError); ok {
+		err = fe.Err
+	}
+	return errors.Cause(err) == target
+}
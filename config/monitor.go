@@ -0,0 +1,351 @@
+package config
+
+import (
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// DefaultMonitorUnit is the unit of feeds that do not set one. fishmon uploads
+// temperatures to Adafruit.IO in degrees Fahrenheit by default.
+const DefaultMonitorUnit = sensor.Fahrenheit
+
+// Trend rule defaults.
+const (
+	DefaultRateWindow      = Duration(time.Hour)
+	DefaultBaselineWindow  = Duration(24 * time.Hour)
+	DefaultForecastWindow  = Duration(time.Hour)
+	DefaultForecastHorizon = Duration(time.Hour)
+)
+
+// Monitor stores the contents of an fmmon configuration file, which sets the
+// safe ranges of each monitored Adafruit.IO feed, and where alerts are sent.
+type Monitor struct {
+	// Feeds maps feed keys, such as "fish.shrimp-tank", to their settings.
+	Feeds     map[string]MonitorFeed `json:"feeds"`
+	Notifiers Notifiers              `json:"notifiers"`
+}
+
+// MonitorFeed configures the monitoring of a single feed.
+type MonitorFeed struct {
+	// Unit is the unit that the feed's values are uploaded in, and that its
+	// ranges and limits are given in. Values are not converted, so it must
+	// match the unit that fishmon uploads the feed in. If empty, it is
+	// DefaultMonitorUnit.
+	Unit sensor.Unit `json:"unit"`
+	// Warning is the range outside of which values raise a warning. It
+	// should lie within Critical. If nil, there are no warnings.
+	Warning *Range `json:"warning"`
+	// Critical is the range outside of which values raise a critical alert.
+	// If nil, there are no critical alerts.
+	Critical *Range `json:"critical"`
+	// MaxAge is how long the feed can go without updates before it is
+	// stale. If zero, fmmon's default applies.
+	MaxAge Duration `json:"max_age"`
+
+	// Rate alerts when the feed changes faster than its limits, in units per
+	// hour, over its window. If its window is zero, it is DefaultRateWindow.
+	Rate *Tolerance `json:"rate"`
+	// Baseline alerts when the feed's latest value is further than its
+	// limits from the feed's mean over its window. If its window is zero, it
+	// is DefaultBaselineWindow.
+	Baseline *Tolerance `json:"baseline"`
+	// Forecast alerts when the feed's trend will take it out of its critical
+	// range soon.
+	Forecast *Forecast `json:"forecast"`
+}
+
+// A Tolerance limits how far a feed may move over a window of time. Either
+// limit may be nil to leave it out.
+type Tolerance struct {
+	Window   Duration `json:"window"`
+	Warning  *float64 `json:"warning"`
+	Critical *float64 `json:"critical"`
+}
+
+// A Forecast fits a line to a feed's values over Window, and warns when the
+// line crosses the feed's critical range within Horizon. If zero, they are
+// DefaultForecastWindow and DefaultForecastHorizon.
+type Forecast struct {
+	Window  Duration `json:"window"`
+	Horizon Duration `json:"horizon"`
+}
+
+// Notifiers configures where fmmon sends alerts. A nil notifier is disabled.
+type Notifiers struct {
+	Slack    *Slack    `json:"slack"`
+	Discord  *Discord  `json:"discord"`
+	Webhook  *Webhook  `json:"webhook"`
+	Email    *Email    `json:"email"`
+	Ntfy     *Ntfy     `json:"ntfy"`
+	Gotify   *Gotify   `json:"gotify"`
+	Pushover *Pushover `json:"pushover"`
+}
+
+// Slack configures posting alerts to a Slack incoming webhook, or any webhook
+// that accepts Slack's message format.
+type Slack struct {
+	URL string `json:"url"`
+}
+
+// Discord configures posting alerts to a Discord webhook.
+type Discord struct {
+	URL string `json:"url"`
+}
+
+// Webhook configures sending alerts to an HTTP endpoint. Template is a Go
+// text/template that renders the request body from the alerts; if empty, the
+// alerts are sent as JSON.
+type Webhook struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers"`
+	ContentType string            `json:"content_type"`
+	Template    string            `json:"template"`
+}
+
+// Email configures sending alerts by email through an SMTP server. The
+// connection is upgraded with STARTTLS if the server supports it, and
+// authenticated if Username is set.
+type Email struct {
+	Addr     string   `json:"addr"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Ntfy configures publishing alerts to an ntfy topic, whose URL is the server
+// followed by the topic, such as "https://ntfy.sh/my-fish".
+type Ntfy struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// Gotify configures pushing alerts to a Gotify server with an application
+// token.
+type Gotify struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// Pushover configures pushing alerts through the Pushover API. URL is only
+// needed for Pushover compatible servers.
+type Pushover struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	User  string `json:"user"`
+}
+
+// WithDefaults returns the feed's settings with the windows of its trend rules
+// filled in.
+func (f MonitorFeed) WithDefaults() MonitorFeed {
+	if f.Rate != nil {
+		r := *f.Rate
+		if r.Window == 0 {
+			r.Window = DefaultRateWindow
+		}
+		f.Rate = &r
+	}
+	if f.Baseline != nil {
+		b := *f.Baseline
+		if b.Window == 0 {
+			b.Window = DefaultBaselineWindow
+		}
+		f.Baseline = &b
+	}
+	if f.Forecast != nil {
+		fc := *f.Forecast
+		if fc.Window == 0 {
+			fc.Window = DefaultForecastWindow
+		}
+		if fc.Horizon == 0 {
+			fc.Horizon = DefaultForecastHorizon
+		}
+		f.Forecast = &fc
+	}
+	return f
+}
+
+// UnitOrDefault returns the feed's unit, defaulting to DefaultMonitorUnit.
+func (f MonitorFeed) UnitOrDefault() sensor.Unit {
+	if f.Unit == "" {
+		return DefaultMonitorUnit
+	}
+	return f.Unit
+}
+
+// A Range bounds acceptable values. Either side may be nil to leave it open.
+type Range struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// Below returns whether a value is below the range.
+func (r *Range) Below(v float64) bool {
+	return r != nil && r.Min != nil && v < *r.Min
+}
+
+// Above returns whether a value is above the range.
+func (r *Range) Above(v float64) bool {
+	return r != nil && r.Max != nil && v > *r.Max
+}
+
+// NewMonitor parses an fmmon configuration file.
+func NewMonitor(filename string) (*Monitor, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read fmmon configuration file")
+	}
+	m, err := ParseMonitor(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid fmmon configuration file")
+	}
+	return m, nil
+}
+
+// ParseMonitor parses and validates the contents of an fmmon configuration
+// file. Unknown fields are rejected.
+func ParseMonitor(data []byte) (*Monitor, error) {
+	m := &Monitor{}
+	if err := decodeStrict(data, m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate checks that each feed's unit measures temperature, that its ranges
+// are in order and its trend rules can fire, and that notifiers have their
+// required fields. All problems are returned as Errors.
+func (m *Monitor) Validate() error {
+	var v validator
+	for key, f := range m.Feeds {
+		path := indexPath("feeds", key)
+		if key == "" {
+			v.errorf(path, errors.New("feed key must not be empty"))
+		}
+		switch kind := unitKind(f.UnitOrDefault()); kind {
+		case sensor.Temperature:
+		case "":
+			v.errorf(joinPath(path, "unit"), errors.Wrapf(ErrUnknownUnit, "%q", f.Unit))
+		default:
+			v.errorf(joinPath(path, "unit"), errors.Wrapf(ErrIncompatible, "%q for %s", f.Unit, sensor.Temperature))
+		}
+		f.Warning.validate(&v, joinPath(path, "warning"))
+		f.Critical.validate(&v, joinPath(path, "critical"))
+		if w, c := f.Warning, f.Critical; w != nil && c != nil {
+			if w.Min != nil && c.Min != nil && *w.Min < *c.Min {
+				v.errorf(joinPath(path, "warning.min"), errors.New("must not be less than critical.min"))
+			}
+			if w.Max != nil && c.Max != nil && *w.Max > *c.Max {
+				v.errorf(joinPath(path, "warning.max"), errors.New("must not be greater than critical.max"))
+			}
+		}
+		if f.MaxAge < 0 {
+			v.errorf(joinPath(path, "max_age"), ErrNegative)
+		}
+		f.Rate.validate(&v, joinPath(path, "rate"))
+		f.Baseline.validate(&v, joinPath(path, "baseline"))
+		if fc := f.Forecast; fc != nil {
+			if fc.Window < 0 {
+				v.errorf(joinPath(path, "forecast.window"), ErrNegative)
+			}
+			if fc.Horizon < 0 {
+				v.errorf(joinPath(path, "forecast.horizon"), ErrNegative)
+			}
+			if f.Critical == nil {
+				v.errorf(joinPath(path, "forecast"), errors.New("requires a critical range"))
+			}
+		}
+	}
+
+	m.Notifiers.validate(&v, "notifiers")
+
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Path < v.errs[j].Path })
+		return v.errs
+	}
+	return nil
+}
+
+func (r *Range) validate(v *validator, path string) {
+	if r == nil {
+		return
+	}
+	if r.Min == nil && r.Max == nil {
+		v.errorf(path, errors.New("set at least one of min or max"))
+	}
+	if r.Min != nil && r.Max != nil && *r.Min >= *r.Max {
+		v.errorf(joinPath(path, "max"), ErrEmptyRange)
+	}
+}
+
+func (t *Tolerance) validate(v *validator, path string) {
+	if t == nil {
+		return
+	}
+	if t.Window < 0 {
+		v.errorf(joinPath(path, "window"), ErrNegative)
+	}
+	if t.Warning == nil && t.Critical == nil {
+		v.errorf(path, errors.New("set at least one of warning or critical"))
+	}
+	if t.Warning != nil && *t.Warning <= 0 {
+		v.errorf(joinPath(path, "warning"), errors.New("must be positive"))
+	}
+	if t.Critical != nil && *t.Critical <= 0 {
+		v.errorf(joinPath(path, "critical"), errors.New("must be positive"))
+	}
+	if t.Warning != nil && t.Critical != nil && *t.Warning > *t.Critical {
+		v.errorf(joinPath(path, "warning"), errors.New("must not be greater than critical"))
+	}
+}
+
+func (n *Notifiers) validate(v *validator, path string) {
+	if n.Slack != nil {
+		validateURL(v, joinPath(path, "slack.url"), n.Slack.URL, true)
+	}
+	if n.Discord != nil {
+		validateURL(v, joinPath(path, "discord.url"), n.Discord.URL, true)
+	}
+	if w := n.Webhook; w != nil {
+		validateURL(v, joinPath(path, "webhook.url"), w.URL, true)
+	}
+	if e := n.Email; e != nil {
+		path := joinPath(path, "email")
+		if e.Addr == "" {
+			v.errorf(joinPath(path, "addr"), ErrRequired)
+		}
+		if e.From == "" {
+			v.errorf(joinPath(path, "from"), ErrRequired)
+		}
+		if len(e.To) == 0 {
+			v.errorf(joinPath(path, "to"), ErrRequired)
+		}
+	}
+	if n.Ntfy != nil {
+		validateURL(v, joinPath(path, "ntfy.url"), n.Ntfy.URL, true)
+	}
+	if g := n.Gotify; g != nil {
+		validateURL(v, joinPath(path, "gotify.url"), g.URL, true)
+		if g.Token == "" {
+			v.errorf(joinPath(path, "gotify.token"), ErrRequired)
+		}
+	}
+	if p := n.Pushover; p != nil {
+		validateURL(v, joinPath(path, "pushover.url"), p.URL, false)
+		if p.Token == "" {
+			v.errorf(joinPath(path, "pushover.token"), ErrRequired)
+		}
+		if p.User == "" {
+			v.errorf(joinPath(path, "pushover.user"), ErrRequired)
+		}
+	}
+}
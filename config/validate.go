@@ -0,0 +1,305 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/aggregate"
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// A FieldError is a problem with the value at a JSON path in a configuration
+// file, such as `sensors["28-02089245bf26"].tank`.
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Cause returns the underlying error, for errors.Cause.
+func (e *FieldError) Cause() error {
+	return e.Err
+}
+
+// Errors are the problems found when validating a configuration file.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// joinPath returns the path of an object field.
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// indexPath returns the path of a map value or array element.
+func indexPath(path string, key interface{}) string {
+	if s, ok := key.(string); ok {
+		return fmt.Sprintf("%s[%q]", path, s)
+	}
+	return fmt.Sprintf("%s[%v]", path, key)
+}
+
+// validator collects validation errors.
+type validator struct {
+	errs Errors
+}
+
+func (v *validator) errorf(path string, err error) {
+	v.errs = append(v.errs, &FieldError{Path: path, Err: err})
+}
+
+// Validate checks that a configuration is consistent: that references between
+// tanks, sensors and alerts resolve, units suit their sensors, and sinks have
+// their required settings. All problems are returned as Errors.
+func (f *File) Validate() error {
+	var v validator
+
+	if f.Version != CurrentVersion {
+		v.errorf("version", errors.Wrapf(ErrUnknownVersion, "%q", f.Version))
+	}
+	if f.Sampling.Interval < 0 {
+		v.errorf("sampling.interval", ErrNegative)
+	}
+	if f.Sampling.UploadInterval < 0 {
+		v.errorf("sampling.upload_interval", ErrNegative)
+	}
+	if f.Sampling.Attempts < 0 {
+		v.errorf("sampling.attempts", ErrNegative)
+	}
+	if f.Sampling.QuarantineAfter < 0 {
+		v.errorf("sampling.quarantine_after", ErrNegative)
+	}
+	if f.Sampling.QuarantineBackoff < 0 {
+		v.errorf("sampling.quarantine_backoff", ErrNegative)
+	}
+	if f.Sampling.MaxBackoff < 0 {
+		v.errorf("sampling.max_backoff", ErrNegative)
+	}
+	if f.Sampling.Filter.MaxRate < 0 {
+		v.errorf("sampling.filter.max_rate", ErrNegative)
+	}
+	if f.Sampling.Filter.MedianWindow < 0 {
+		v.errorf("sampling.filter.median_window", ErrNegative)
+	}
+	if s := f.Sampling.WithDefaults(); s.MaxBackoff < s.QuarantineBackoff {
+		v.errorf("sampling.max_backoff", errors.New("must not be less than quarantine_backoff"))
+	}
+
+	for key := range f.Tanks {
+		if key == "" {
+			v.errorf(indexPath("tanks", key), errors.New("tank key must not be empty"))
+		}
+	}
+
+	for id, s := range f.Sensors {
+		path := indexPath("sensors", string(id))
+		if id == "" {
+			v.errorf(path, errors.New("sensor ID must not be empty"))
+		}
+		if s.Tank != "" {
+			if _, ok := f.Tanks[s.Tank]; !ok {
+				v.errorf(joinPath(path, "tank"), errors.Wrapf(ErrNoSuchTank, "%q", s.Tank))
+			}
+		}
+		if s.Interval < 0 {
+			v.errorf(joinPath(path, "interval"), ErrNegative)
+		}
+		if s.Kind != "" && !knownKind(s.Kind) {
+			v.errorf(joinPath(path, "kind"), errors.Wrapf(ErrUnknownKind, "%q", s.Kind))
+		}
+		if s.Resolution != 0 && (s.Resolution < ds18b20.MinResolution || s.Resolution > ds18b20.MaxResolution) {
+			v.errorf(joinPath(path, "resolution"), ds18b20.ErrInvalidResolution)
+		}
+		if a := s.Alarms; a != nil {
+			if err := (ds18b20.Alarms{Low: a.Low, High: a.High}).Validate(); err != nil {
+				v.errorf(joinPath(path, "alarms"), ds18b20.ErrInvalidAlarms)
+			}
+		}
+		for stat, feed := range s.Feeds {
+			path := indexPath(joinPath(path, "feeds"), string(stat))
+			switch {
+			case !stat.Known():
+				v.errorf(path, errors.Wrapf(ErrUnknownStatistic, "%q", stat))
+			case feed == "":
+				v.errorf(path, ErrRequired)
+			case stat == aggregate.Mean && s.FeedKey != "" && feed != s.FeedKey:
+				v.errorf(path, errors.New("conflicts with feed, which the mean is sent to"))
+			}
+		}
+	}
+
+	f.Sinks.validate(&v, "sinks")
+
+	switch f.Unconfigured.Policy {
+	case "", Skip, LogOnly:
+	case CreateFeed:
+		if f.Unconfigured.Group == "" {
+			v.errorf("unconfigured.group", ErrRequired)
+		}
+	default:
+		v.errorf("unconfigured.policy", errors.Errorf("unknown unconfigured probe policy %q", f.Unconfigured.Policy))
+	}
+
+	for i, a := range f.Alerts {
+		a.validate(&v, indexPath("alerts", i), f)
+	}
+
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Path < v.errs[j].Path })
+		return v.errs
+	}
+	return nil
+}
+
+func (s *Sinks) validate(v *validator, path string) {
+	if a := s.AdafruitIO; a != nil {
+		path := joinPath(path, "adafruitio")
+		if a.Username == "" {
+			v.errorf(joinPath(path, "username"), ErrRequired)
+		}
+		if a.Key == "" {
+			v.errorf(joinPath(path, "key"), ErrRequired)
+		}
+		validateURL(v, joinPath(path, "url"), a.URL, false)
+		if a.Timeout < 0 {
+			v.errorf(joinPath(path, "timeout"), ErrNegative)
+		}
+		if a.Tier != "" && a.Tier.RateLimit() == 0 {
+			v.errorf(joinPath(path, "tier"), errors.Errorf("unknown Adafruit.IO account tier %q", a.Tier))
+		}
+		switch a.Transport {
+		case "", "http", "mqtt":
+		default:
+			v.errorf(joinPath(path, "transport"), errors.Errorf("unknown Adafruit.IO transport %q", a.Transport))
+		}
+		if a.TemperatureUnit != "" && unitKind(a.TemperatureUnit) != sensor.Temperature {
+			v.errorf(joinPath(path, "temperature_unit"), errors.Wrapf(ErrIncompatible, "%q", a.TemperatureUnit))
+		}
+	}
+	if s.File != nil && s.File.Path == "" {
+		v.errorf(joinPath(path, "file.path"), ErrRequired)
+	}
+	if s.InfluxDB != nil {
+		validateURL(v, joinPath(path, "influxdb.url"), s.InfluxDB.URL, true)
+	}
+	if s.Pushgateway != nil {
+		validateURL(v, joinPath(path, "pushgateway.url"), s.Pushgateway.URL, true)
+	}
+	if s.MQTT != nil && s.MQTT.Addr == "" {
+		v.errorf(joinPath(path, "mqtt.addr"), ErrRequired)
+	}
+}
+
+func (a *Alert) validate(v *validator, path string, f *File) {
+	if a.Name == "" {
+		v.errorf(joinPath(path, "name"), ErrRequired)
+	}
+
+	var kind sensor.Kind
+	switch {
+	case (a.Sensor == "") == (a.Tank == ""):
+		v.errorf(path, ErrAmbiguousTarget)
+	case a.Sensor != "":
+		s, ok := f.Sensors[a.Sensor]
+		if !ok {
+			v.errorf(joinPath(path, "sensor"), errors.Wrapf(ErrNoSuchSensor, "%q", a.Sensor))
+		}
+		kind = s.KindOrDefault()
+	default:
+		if _, ok := f.Tanks[a.Tank]; !ok {
+			v.errorf(joinPath(path, "tank"), errors.Wrapf(ErrNoSuchTank, "%q", a.Tank))
+		}
+		kind = sensor.Temperature
+	}
+
+	if a.Min == nil && a.Max == nil {
+		v.errorf(path, errors.New("set at least one of min or max"))
+	}
+	if a.Min != nil && a.Max != nil && *a.Min >= *a.Max {
+		v.errorf(joinPath(path, "max"), ErrEmptyRange)
+	}
+	switch {
+	case a.Unit == "":
+		v.errorf(joinPath(path, "unit"), ErrRequired)
+	case unitKind(a.Unit) == "":
+		v.errorf(joinPath(path, "unit"), errors.Wrapf(ErrUnknownUnit, "%q", a.Unit))
+	case unitKind(a.Unit) != kind:
+		v.errorf(joinPath(path, "unit"), errors.Wrapf(ErrIncompatible, "%q for %s", a.Unit, kind))
+	}
+	if a.For < 0 {
+		v.errorf(joinPath(path, "for"), ErrNegative)
+	}
+}
+
+// validateURL checks that a URL is absolute. If required is false, an empty
+// URL is allowed.
+func validateURL(v *validator, path, raw string, required bool) {
+	if raw == "" {
+		if required {
+			v.errorf(path, ErrRequired)
+		}
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		v.errorf(path, err)
+		return
+	}
+	if u.Scheme == "" || u.Host == "" {
+		v.errorf(path, errors.Errorf("%q is not an absolute URL", raw))
+	}
+}
+
+// KindOrDefault returns the sensor's kind, defaulting to temperature.
+func (s Sensor) KindOrDefault() sensor.Kind {
+	if s.Kind == "" {
+		return sensor.Temperature
+	}
+	return s.Kind
+}
+
+func knownKind(k sensor.Kind) bool {
+	switch k {
+	case sensor.Temperature, sensor.PH, sensor.TDS, sensor.WaterLevel:
+		return true
+	default:
+		return false
+	}
+}
+
+// unitKind returns the kind of quantity that a unit measures, or the empty
+// kind for unknown units.
+func unitKind(u sensor.Unit) sensor.Kind {
+	switch u {
+	case sensor.Celsius, sensor.Fahrenheit:
+		return sensor.Temperature
+	case sensor.PHScale:
+		return sensor.PH
+	case sensor.PPM:
+		return sensor.TDS
+	case sensor.Centimeters:
+		return sensor.WaterLevel
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ReloadDelay is how long a Watcher waits for a changed configuration file to
+// settle before reloading it, since editors often write files in several
+// steps.
+const ReloadDelay = 250 * time.Millisecond
+
+// A Watcher holds the current configuration from a file, and reloads it when
+// the file changes. A new configuration only replaces the current one if it is
+// valid. A Watcher is safe for concurrent use.
+type Watcher struct {
+	// ErrorLog logs failed reloads. If nil, the standard logger is used.
+	ErrorLog *log.Logger
+	// OnReload, if not nil, is called with the old and new configurations
+	// after each successful reload. It must be set before the file changes.
+	OnReload func(old, new *File)
+
+	filename string
+	reload   sync.Mutex
+
+	mu      sync.RWMutex
+	current *File
+	timer   *time.Timer
+	closed  bool
+
+	stop func() error
+}
+
+// Watch loads a configuration file, and starts watching it for changes.
+func Watch(filename string) (*Watcher, error) {
+	file, err := New(filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		filename: filename,
+		current:  file,
+	}
+	if w.stop, err = w.watch(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Config returns the current configuration. Callers must not modify it.
+func (w *Watcher) Config() *File {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.current
+}
+
+// Reload reads the configuration file again. If the file is invalid, the
+// current configuration is kept and the error is returned.
+func (w *Watcher) Reload() error {
+	w.reload.Lock()
+	defer w.reload.Unlock()
+
+	file, err := New(w.filename)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	old := w.current
+	w.current = file
+	w.mu.Unlock()
+
+	if w.OnReload != nil {
+		w.OnReload(old, file)
+	}
+	return nil
+}
+
+// Close stops watching the configuration file.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return w.stop()
+}
+
+// changed schedules a reload after the file has settled.
+func (w *Watcher) changed() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Reset(ReloadDelay)
+		return
+	}
+	w.timer = time.AfterFunc(ReloadDelay, func() {
+		if err := w.Reload(); err != nil {
+			w.logf("keeping current configuration, could not reload %s: %s", w.filename, err.Error())
+		}
+	})
+}
+
+func (w *Watcher) logf(format string, args ...interface{}) {
+	if w.ErrorLog != nil {
+		w.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
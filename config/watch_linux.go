@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// watch uses inotify to watch the directory containing the configuration file,
+// so that the file is still watched after editors replace it.
+func (w *Watcher) watch() (func() error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not set up inotify")
+	}
+	dir, name := filepath.Split(w.filename)
+	if dir == "" {
+		dir = "."
+	}
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, errors.Wrapf(err, "could not watch %s", dir)
+	}
+
+	// Wrapping the non-blocking descriptor in a file lets Close interrupt
+	// pending reads.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				start := off + syscall.SizeofInotifyEvent
+				end := start + int(event.Len)
+				if eventName(buf[start:end]) == name {
+					w.changed()
+				}
+				off = end
+			}
+		}
+	}()
+	return f.Close, nil
+}
+
+// eventName returns the NUL-padded file name of an inotify event.
+func eventName(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,45 @@
+//go:build !linux
+// +build !linux
+
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// PollInterval is how often the configuration file is checked for changes on
+// systems without inotify.
+const PollInterval = 5 * time.Second
+
+// watch polls the configuration file's modification time.
+func (w *Watcher) watch() (func() error, error) {
+	info, err := os.Stat(w.filename)
+	if err != nil {
+		return nil, err
+	}
+	last := info.ModTime()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(PollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(w.filename)
+			if err != nil || info.ModTime().Equal(last) {
+				continue
+			}
+			last = info.ModTime()
+			w.changed()
+		}
+	}()
+	return func() error {
+		close(done)
+		return nil
+	}, nil
+}
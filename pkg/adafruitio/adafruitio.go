@@ -3,55 +3,157 @@ package adafruitio
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// Client defaults.
+const (
+	DefaultBaseURL   = "https://io.adafruit.com/api/v2/"
+	DefaultTimeout   = 30 * time.Second
+	DefaultUserAgent = "fishmon"
+)
+
+// DefaultClient is the unauthenticated client used by the package-level API
+// functions.
+var DefaultClient = NewPublic()
+
 type response struct {
 	Error string `json:"error"`
 }
 
-// Do sets request headers, sends a request, checks for API errors, and
-// returns the request body.
+// An APIError is returned when Adafruit.IO responds to a request with an
+// error.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Permanent returns whether the request was rejected in a way that sending it
+// again cannot fix, such as a request for a feed that does not exist or with
+// an invalid value. Rejected credentials are not permanent, since they can be
+// fixed without changing the request.
+func (e *APIError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// Do sets request headers, sends a request using DefaultClient, checks for API
+// errors, and returns the request body.
 func Do(req *http.Request) ([]byte, error) {
+	return DefaultClient.Do(req)
+}
+
+// Do sets request headers, sends a request, checks for API errors, and
+// returns the request body. Requests are authenticated if the client has an
+// API key.
+func (c *Client) Do(req *http.Request) ([]byte, error) {
+	_, body, err := c.do(req, 0)
+	return body, err
+}
+
+// do is like Do, but also returns the response, whose body has already been
+// read and closed. Requests that record data points should set points to the
+// number of points recorded, so that they are rate limited.
+//
+// Throttled requests pause all of the client's requests for as long as the
+// server asks, and are retried up to the client's retry limit.
+func (c *Client) do(req *http.Request, points int) (*http.Response, []byte, error) {
+	c.limiter.Wait(points)
+	for attempt := 0; ; attempt++ {
+		res, body, err := c.send(req)
+		throttled, ok := errors.Cause(err).(*ThrottleError)
+		if !ok {
+			return res, body, err
+		}
+		c.limiter.Pause(throttled.RetryAfter)
+		if attempt >= c.retries {
+			return res, body, err
+		}
+
+		// Rewind the request body before retrying.
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return res, body, err
+			}
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, nil, errors.Wrap(err, "could not rewind Adafruit API request body")
+			}
+		}
+		c.limiter.Wait(0)
+	}
+}
+
+// send sends a single API request.
+func (c *Client) send(req *http.Request) (*http.Response, []byte, error) {
 	// Set headers.
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.apiKey != "" {
+		Authenticate(req, c.apiKey)
+	}
 
 	// Send request.
-	res, err := http.DefaultClient.Do(req)
+	res, err := c.http.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not send Adafruit API request")
+		return nil, nil, errors.Wrap(err, "could not send Adafruit API request")
 	}
 	defer res.Body.Close()
 
 	// Parse response body.
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not read Adafruit API response body")
+		return nil, nil, errors.Wrap(err, "could not read Adafruit API response body")
 	}
 	var r response
 	err = json.Unmarshal(body, &r)
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, nil, errors.Wrap(
+			throttleError(res, r.Error), "Adafruit API request was throttled")
+	}
+	if res.StatusCode >= 400 {
+		message := r.Error
+		if message == "" {
+			message = http.StatusText(res.StatusCode)
+		}
+		return nil, nil, errors.Wrap(
+			&APIError{StatusCode: res.StatusCode, Message: message}, "Adafruit API response contains error")
+	}
 	if err != nil {
 		// Special case: some API endpoints normally return arrays, but return
 		// objects when an error occurs. In this case, unmarshalling an array result
 		// to a struct (when the request succeeds) will fail. This is expected and
 		// allowed behaviour.
 		if err, ok := err.(*json.UnmarshalTypeError); !ok || err.Value != "array" {
-			return nil, errors.Wrap(
+			return nil, nil, errors.Wrap(
 				err, "could not unmarshal Adafruit API response body")
 		}
 	}
 
 	// Check for application-level errors.
 	if r.Error != "" {
-		return nil, errors.Wrap(
-			errors.New(r.Error), "Adafruit API response contains error")
+		return nil, nil, errors.Wrap(
+			&APIError{Message: r.Error}, "Adafruit API response contains error")
 	}
 
-	return body, nil
+	return res, body, nil
 }
 
 // Authenticate adds an Adafruit API key header to an HTTP request.
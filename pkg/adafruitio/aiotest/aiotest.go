@@ -0,0 +1,521 @@
+// Package aiotest provides an in-process fake Adafruit.IO server for testing
+// API clients.
+//
+// The server implements the subset of the Adafruit.IO v2 HTTP API that fishmon
+// and fmmon use: validating credentials, listing and creating feeds and group
+// feeds, and reading and writing (singly or in batches) feed data. Like the real
+// service, it reports errors as JSON objects, even from endpoints that normally
+// return arrays.
+package aiotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+)
+
+// APIPath is the path prefix of API endpoints on the server.
+const APIPath = "/api/v2/"
+
+// DefaultPageSize is the number of points returned per page when a request
+// does not specify a limit.
+const DefaultPageSize = 100
+
+// Error messages returned by the server.
+const (
+	ErrUnauthorized = "request failed - Access denied"
+	ErrNotFound     = "not found - API documentation can be found at https://io.adafruit.com/api/docs"
+	ErrThrottled    = "request failed - Your request rate is currently being limited"
+	ErrBadRequest   = "request failed - bad request"
+)
+
+// A Server is a fake Adafruit.IO API server.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	users      map[string]*user
+	throttle   int
+	retryAfter time.Duration
+	pageSize   int
+	requests   []*http.Request
+	nextID     int
+}
+
+type user struct {
+	key    string
+	feeds  map[string]*feed
+	groups map[string][]string
+}
+
+type feed struct {
+	adafruitio.Feed
+	public bool
+	points []adafruitio.Point
+}
+
+// feedResponse is the API representation of a feed, which reports feeds
+// without data as having a null last_value_at.
+type feedResponse struct {
+	ID          adafruitio.FeedID `json:"id"`
+	Name        string            `json:"name"`
+	Key         string            `json:"key"`
+	LastValue   *string           `json:"last_value"`
+	LastUpdated *time.Time        `json:"last_value_at"`
+	Visibility  string            `json:"visibility"`
+}
+
+// NewServer starts a fake Adafruit.IO server with no users. The caller should
+// call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		users:    make(map[string]*user),
+		pageSize: DefaultPageSize,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// BaseURL returns the API base URL of the server, suitable for use with
+// adafruitio.WithBaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + APIPath
+}
+
+// AddUser adds a user with an API key.
+func (s *Server) AddUser(username, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = &user{
+		key:    key,
+		feeds:  make(map[string]*feed),
+		groups: make(map[string][]string),
+	}
+}
+
+// AddFeed adds a feed to a user's group, creating the group and user if
+// necessary. If group is empty, the feed is not in any group. Public feeds can
+// be read without an API key.
+func (s *Server) AddFeed(username, group, key, name string, public bool) adafruitio.Feed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addFeed(username, group, key, name, public)
+}
+
+// addFeed adds a feed. It must be called with the lock held.
+func (s *Server) addFeed(username, group, key, name string, public bool) adafruitio.Feed {
+	u, ok := s.users[username]
+	if !ok {
+		u = &user{
+			feeds:  make(map[string]*feed),
+			groups: make(map[string][]string),
+		}
+		s.users[username] = u
+	}
+	s.nextID++
+	f := &feed{
+		Feed: adafruitio.Feed{
+			ID:   adafruitio.FeedID(s.nextID),
+			Name: name,
+			Key:  key,
+		},
+		public: public,
+	}
+	u.feeds[key] = f
+	if group != "" {
+		u.groups[group] = append(u.groups[group], key)
+	}
+	return f.Feed
+}
+
+// AddPoint adds a data point to a user's feed, as if it were recorded through
+// the API.
+func (s *Server) AddPoint(username, key, value string, createdAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.users[username].feeds[key]
+	s.record(f, value, createdAt)
+}
+
+// Feed returns the current state of a user's feed.
+func (s *Server) Feed(username, key string) (adafruitio.Feed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return adafruitio.Feed{}, false
+	}
+	f, ok := u.feeds[key]
+	if !ok {
+		return adafruitio.Feed{}, false
+	}
+	return f.Feed, true
+}
+
+// Points returns the data points of a user's feed, oldest first.
+func (s *Server) Points(username, key string) []adafruitio.Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[username]
+	if !ok {
+		return nil
+	}
+	f, ok := u.feeds[key]
+	if !ok {
+		return nil
+	}
+	return append([]adafruitio.Point(nil), f.points...)
+}
+
+// Throttle causes the next n requests to fail with HTTP 429 Too Many Requests.
+// If retryAfter is non-zero, throttled responses include a Retry-After header.
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle = n
+	s.retryAfter = retryAfter
+}
+
+// SetPageSize sets the number of points returned per page when a request does
+// not specify a limit.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// Requests returns the requests that the server has received, in order.
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+// record adds a point to a feed. It must be called with the lock held.
+func (s *Server) record(f *feed, value string, createdAt time.Time) adafruitio.Point {
+	s.nextID++
+	now := time.Now().UTC()
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	point := adafruitio.Point{
+		ID:        fmt.Sprintf("%026d", s.nextID),
+		Value:     value,
+		CreatedAt: createdAt.UTC(),
+		UpdatedAt: now,
+	}
+	f.points = append(f.points, point)
+	sort.SliceStable(f.points, func(i, j int) bool {
+		return f.points[i].CreatedAt.Before(f.points[j].CreatedAt)
+	})
+
+	// The last value is the most recently created point.
+	last := f.points[len(f.points)-1]
+	f.LastValue = last.Value
+	f.LastUpdated = last.CreatedAt
+	return point
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+
+	// Throttle requests.
+	if s.throttle > 0 {
+		s.throttle--
+		if s.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((s.retryAfter+time.Second-1)/time.Second)))
+		}
+		writeError(w, http.StatusTooManyRequests, ErrThrottled)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, APIPath) {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/"), "/")
+
+	// GET /user
+	if len(path) == 1 && path[0] == "user" && r.Method == http.MethodGet {
+		s.serveUser(w, r)
+		return
+	}
+
+	u, ok := s.users[path[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+	authenticated := u.key != "" && r.Header.Get("X-AIO-Key") == u.key
+
+	switch {
+	// GET /{user}/feeds
+	case len(path) == 2 && path[1] == "feeds" && r.Method == http.MethodGet:
+		var keys []string
+		for key := range u.feeds {
+			keys = append(keys, key)
+		}
+		s.serveFeeds(w, u, keys, authenticated)
+
+	// GET /{user}/groups/{group}/feeds
+	case len(path) == 4 && path[1] == "groups" && path[3] == "feeds" && r.Method == http.MethodGet:
+		keys, ok := u.groups[path[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		s.serveFeeds(w, u, keys, authenticated)
+
+	// POST /{user}/feeds
+	case len(path) == 2 && path[1] == "feeds" && r.Method == http.MethodPost:
+		if !authenticated {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		s.serveCreateFeed(w, r, path[0], "")
+
+	// POST /{user}/groups/{group}/feeds
+	case len(path) == 4 && path[1] == "groups" && path[3] == "feeds" && r.Method == http.MethodPost:
+		if !authenticated {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		s.serveCreateFeed(w, r, path[0], path[2])
+
+	// GET /{user}/feeds/{key}/data
+	case len(path) == 4 && path[1] == "feeds" && path[3] == "data" && r.Method == http.MethodGet:
+		f, ok := u.feeds[path[2]]
+		if !ok || !(f.public || authenticated) {
+			writeError(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		s.serveData(w, r, f)
+
+	// POST /{user}/feeds/{key}/data
+	case len(path) == 4 && path[1] == "feeds" && path[3] == "data" && r.Method == http.MethodPost:
+		if !authenticated {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		f, ok := u.feeds[path[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		s.serveRecord(w, r, f)
+
+	// POST /{user}/feeds/{key}/data/batch
+	case len(path) == 5 && path[1] == "feeds" && path[3] == "data" && path[4] == "batch" && r.Method == http.MethodPost:
+		if !authenticated {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		f, ok := u.feeds[path[2]]
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound)
+			return
+		}
+		s.serveBatch(w, r, f)
+
+	default:
+		writeError(w, http.StatusNotFound, ErrNotFound)
+	}
+}
+
+func (s *Server) serveUser(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("X-AIO-Key")
+	for username, u := range s.users {
+		if key != "" && u.key == key {
+			writeJSON(w, http.StatusOK, map[string]string{"username": username})
+			return
+		}
+	}
+	writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+}
+
+func (s *Server) serveFeeds(w http.ResponseWriter, u *user, keys []string, authenticated bool) {
+	feeds := []feedResponse{}
+	for _, key := range keys {
+		f := u.feeds[key]
+		if !f.public && !authenticated {
+			continue
+		}
+		res := feedResponse{
+			ID:         f.ID,
+			Name:       f.Name,
+			Key:        f.Key,
+			Visibility: "private",
+		}
+		if f.public {
+			res.Visibility = "public"
+		}
+		if len(f.points) > 0 {
+			lastValue, lastUpdated := f.LastValue, f.LastUpdated
+			res.LastValue = &lastValue
+			res.LastUpdated = &lastUpdated
+		}
+		feeds = append(feeds, res)
+	}
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].ID < feeds[j].ID
+	})
+	writeJSON(w, http.StatusOK, feeds)
+}
+
+// serveData serves a page of points, newest first. Like Adafruit.IO, the
+// start_time and end_time parameters are inclusive, and the Link header points
+// to the next page of older points.
+func (s *Server) serveData(w http.ResponseWriter, r *http.Request, f *feed) {
+	query := r.URL.Query()
+	var start, end time.Time
+	var err error
+	if v := query.Get("start_time"); v != "" {
+		if start, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, ErrBadRequest)
+			return
+		}
+	}
+	if v := query.Get("end_time"); v != "" {
+		if end, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, ErrBadRequest)
+			return
+		}
+	}
+	limit := s.pageSize
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, ErrBadRequest)
+			return
+		}
+		if limit > adafruitio.MaxPageSize {
+			limit = adafruitio.MaxPageSize
+		}
+	}
+
+	// Select matching points.
+	matches := []adafruitio.Point{}
+	for i := len(f.points) - 1; i >= 0; i-- {
+		point := f.points[i]
+		created := point.CreatedAt.Truncate(time.Second)
+		if !start.IsZero() && created.Before(start) {
+			continue
+		}
+		if !end.IsZero() && created.After(end) {
+			continue
+		}
+		matches = append(matches, point)
+	}
+	points := matches
+	if len(points) > limit {
+		points = points[:limit]
+	}
+
+	// Set pagination headers.
+	w.Header().Set(adafruitio.HeaderPaginationLimit, strconv.Itoa(limit))
+	w.Header().Set(adafruitio.HeaderPaginationCount, strconv.Itoa(len(points)))
+	w.Header().Set(adafruitio.HeaderPaginationTotal, strconv.Itoa(len(matches)))
+	if len(points) > 0 {
+		w.Header().Set("X-Pagination-Start", points[len(points)-1].CreatedAt.Format(time.RFC3339))
+		w.Header().Set("X-Pagination-End", points[0].CreatedAt.Format(time.RFC3339))
+	}
+	if len(matches) > len(points) {
+		next := *r.URL
+		query.Set("end_time", points[len(points)-1].CreatedAt.Format(time.RFC3339))
+		query.Set("limit", strconv.Itoa(limit))
+		next.RawQuery = query.Encode()
+		w.Header().Set(adafruitio.HeaderLink, fmt.Sprintf(`<%s%s>; rel="next"`, s.URL, next.RequestURI()))
+	}
+
+	writeJSON(w, http.StatusOK, points)
+}
+
+// serveCreateFeed creates a private feed. Like Adafruit.IO, feeds created in a
+// group have keys prefixed by the group key, and the group is created if
+// necessary.
+func (s *Server) serveCreateFeed(w http.ResponseWriter, r *http.Request, username, group string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	var req struct {
+		Feed adafruitio.FeedRequest `json:"feed"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Feed.Name == "" {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	key := req.Feed.Key
+	if key == "" {
+		key = strings.ToLower(strings.Replace(req.Feed.Name, " ", "-", -1))
+	}
+	if group != "" {
+		key = group + "." + key
+	}
+	if _, ok := s.users[username].feeds[key]; ok {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.addFeed(username, group, key, req.Feed.Name, false))
+}
+
+func (s *Server) serveRecord(w http.ResponseWriter, r *http.Request, f *feed) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	var req adafruitio.DataRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Value == "" {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.record(f, req.Value, req.CreatedAt))
+}
+
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request, f *feed) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	var req adafruitio.BatchRequest
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Data) == 0 {
+		writeError(w, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+	for _, point := range req.Data {
+		if point.Value == "" {
+			writeError(w, http.StatusBadRequest, ErrBadRequest)
+			return
+		}
+	}
+	points := []adafruitio.Point{}
+	for _, point := range req.Data {
+		points = append(points, s.record(f, point.Value, point.CreatedAt))
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the shape used by Adafruit.IO: an object with
+// an error message, regardless of the endpoint's normal response type.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -4,43 +4,148 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
-// A Client provides authentication for authenticated Adafruit.IO API requests.
+// A Client sends Adafruit.IO API requests, and provides authentication for
+// authenticated requests.
 type Client struct {
 	username string
 	apiKey   string
+
+	baseURL   string
+	http      *http.Client
+	timeout   time.Duration
+	userAgent string
+	rateLimit int
+	retries   int
+	limiter   *limiter
+}
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the base URL of the Adafruit.IO API. This is useful for
+// pointing clients at a stand-in server.
+func WithBaseURL(url string) Option {
+	return func(c *Client) {
+		c.baseURL = url
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to send API requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithTimeout sets the time limit for each API request, including reading the
+// response body. A zero timeout means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header of API requests.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithRateLimit limits the client to recording perMinute data points per
+// minute. A zero limit means no limit.
+func WithRateLimit(perMinute int) Option {
+	return func(c *Client) {
+		c.rateLimit = perMinute
+	}
+}
+
+// WithTier limits the client to recording data points at the rate limit of an
+// account tier.
+func WithTier(tier Tier) Option {
+	return WithRateLimit(tier.RateLimit())
+}
+
+// WithRetries sets the number of times that throttled requests are retried,
+// after waiting for as long as the server asks.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// NewPublic constructs an unauthenticated Adafruit.IO API client, which can
+// only access public data.
+func NewPublic(opts ...Option) *Client {
+	client := &Client{
+		baseURL:   DefaultBaseURL,
+		http:      http.DefaultClient,
+		timeout:   DefaultTimeout,
+		userAgent: DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	if !strings.HasSuffix(client.baseURL, "/") {
+		client.baseURL += "/"
+	}
+
+	// Apply the timeout to a copy, so that shared HTTP clients are unchanged.
+	httpClient := *client.http
+	httpClient.Timeout = client.timeout
+	client.http = &httpClient
+	client.limiter = newLimiter(client.rateLimit)
+
+	return client
 }
 
 // New constructs an authenticated Adafruit.IO API client, checking to make sure
 // that the credentials are valid.
-func New(username, apiKey string) (*Client, error) {
-	// Construct client.
-	client := &Client{
-		username: username,
-		apiKey:   apiKey,
+func New(username, apiKey string, opts ...Option) (*Client, error) {
+	client := NewUnchecked(username, apiKey, opts...)
+	if err := client.CheckCredentials(); err != nil {
+		return nil, err
 	}
+	return client, nil
+}
 
+// NewUnchecked constructs an authenticated Adafruit.IO API client without
+// checking its credentials, which needs no network. Requests made with invalid
+// credentials fail.
+func NewUnchecked(username, apiKey string, opts ...Option) *Client {
+	client := NewPublic(opts...)
+	client.username = username
+	client.apiKey = apiKey
+	return client
+}
+
+// CheckCredentials checks that the client's credentials are valid.
+func (c *Client) CheckCredentials() error {
 	// Construct API request.
-	req, err := http.NewRequest(
-		http.MethodGet, "https://io.adafruit.com/api/v2/user", nil)
+	req, err := http.NewRequest(http.MethodGet, c.url("user"), nil)
 	if err != nil {
-		return nil, errors.Wrap(
+		return errors.Wrap(
 			err, "could not construct API request to validate credentials")
 	}
 
 	// Check client credentials.
-	Authenticate(req, apiKey)
-	_, err = Do(req)
+	_, err = c.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(
+		return errors.Wrap(
 			err, "API response for validating credentials has error")
 	}
+	return nil
+}
 
-	return client, nil
+// url returns the API URL of a path relative to the base URL.
+func (c *Client) url(path string) string {
+	return c.baseURL + path
 }
 
 // A DataRequest contains an Adafruit feed data point.
@@ -64,7 +169,7 @@ func (c *Client) Record(feed, value string, timestamp time.Time) error {
 	// Construct request.
 	req, err := http.NewRequest(
 		http.MethodPost,
-		"https://io.adafruit.com/api/v2/"+c.username+"/feeds/"+feed+"/data",
+		c.url(c.username+"/feeds/"+feed+"/data"),
 		bytes.NewReader(payload),
 	)
 	if err != nil {
@@ -73,11 +178,94 @@ func (c *Client) Record(feed, value string, timestamp time.Time) error {
 	}
 
 	// Send request.
-	Authenticate(req, c.apiKey)
-	_, err = Do(req)
+	_, _, err = c.do(req, 1)
 	if err != nil {
 		return errors.Wrap(err, "API response for recording data has error")
 	}
 
 	return nil
 }
+
+// A BatchRequest contains several Adafruit feed data points.
+type BatchRequest struct {
+	Data []DataRequest `json:"data"`
+}
+
+// RecordBatch uploads several values to an Adafruit.IO feed in one request.
+func (c *Client) RecordBatch(feed string, points []DataRequest) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	// Marshal request body.
+	payload, err := json.Marshal(BatchRequest{Data: points})
+	if err != nil {
+		return errors.Wrap(
+			err, "could not marshal API request body for recording data batch")
+	}
+
+	// Construct request.
+	req, err := http.NewRequest(
+		http.MethodPost,
+		c.url(c.username+"/feeds/"+feed+"/data/batch"),
+		bytes.NewReader(payload),
+	)
+	if err != nil {
+		return errors.Wrap(
+			err, "could not construct API request for recording data batch")
+	}
+
+	// Send request.
+	_, _, err = c.do(req, len(points))
+	if err != nil {
+		return errors.Wrap(err, "API response for recording data batch has error")
+	}
+
+	return nil
+}
+
+// A FeedRequest contains the settings of a new Adafruit feed.
+type FeedRequest struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+type createFeedRequest struct {
+	Feed FeedRequest `json:"feed"`
+}
+
+// CreateFeed creates a feed in a group. If group is empty, the feed is not
+// added to any group. Feeds in a group have keys prefixed by the group's key,
+// such as "fish.left-tank".
+func (c *Client) CreateFeed(group string, feed FeedRequest) (Feed, error) {
+	// Marshal request body.
+	payload, err := json.Marshal(createFeedRequest{Feed: feed})
+	if err != nil {
+		return Feed{}, errors.Wrap(
+			err, "could not marshal API request body for creating feed")
+	}
+
+	// Construct request.
+	path := c.username + "/feeds"
+	if group != "" {
+		path = c.username + "/groups/" + group + "/feeds"
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url(path), bytes.NewReader(payload))
+	if err != nil {
+		return Feed{}, errors.Wrap(
+			err, "could not construct API request for creating feed")
+	}
+
+	// Send request.
+	_, body, err := c.do(req, 0)
+	if err != nil {
+		return Feed{}, errors.Wrap(err, "API response for creating feed has error")
+	}
+
+	var created Feed
+	if err := json.Unmarshal(body, &created); err != nil {
+		return Feed{}, errors.Wrap(
+			err, "could not unmarshal response body for created feed")
+	}
+	return created, nil
+}
@@ -0,0 +1,261 @@
+package adafruitio
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Pagination response headers.
+const (
+	HeaderLink            = "Link"
+	HeaderPaginationLimit = "X-Pagination-Limit"
+	HeaderPaginationCount = "X-Pagination-Count"
+	HeaderPaginationTotal = "X-Pagination-Total"
+)
+
+// MaxPageSize is the largest number of points that Adafruit.IO returns in a
+// single page.
+const MaxPageSize = 1000
+
+// A DataQuery selects the points of a feed to retrieve.
+type DataQuery struct {
+	// Start and End bound the creation times of points, inclusively. Zero times
+	// are unbounded.
+	Start time.Time
+	End   time.Time
+	// Limit is the number of points to request per page. If Limit is zero, the
+	// server's default page size is used.
+	Limit int
+}
+
+func (q DataQuery) values() url.Values {
+	values := make(url.Values)
+	if !q.Start.IsZero() {
+		values.Set("start_time", q.Start.UTC().Truncate(time.Second).Format(time.RFC3339))
+	}
+	if !q.End.IsZero() {
+		// Round the end time up, so that the whole requested range is included.
+		end := q.End.UTC().Truncate(time.Second)
+		if end.Before(q.End) {
+			end = end.Add(time.Second)
+		}
+		values.Set("end_time", end.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return values
+}
+
+// A DataIterator iterates over the points of a feed, newest first, requesting
+// pages from the API as needed.
+//
+//	it := client.Query(user, feed, adafruitio.DataQuery{Start: since})
+//	for it.Next() {
+//		point := it.Point()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type DataIterator struct {
+	client *Client
+	query  DataQuery
+	next   string
+
+	page  []Point
+	point Point
+	err   error
+
+	// seen contains the IDs of the points returned in the oldest second so
+	// far, which may be returned again by the next page, since pages are
+	// bounded at second precision.
+	seen   map[string]bool
+	oldest time.Time
+}
+
+// Query returns an iterator over the points in a feed that match a query.
+func (c *Client) Query(user, feed string, query DataQuery) *DataIterator {
+	next := c.url(user + "/feeds/" + feed + "/data")
+	if values := query.values(); len(values) > 0 {
+		next += "?" + values.Encode()
+	}
+	return &DataIterator{
+		client: c,
+		query:  query,
+		next:   next,
+		seen:   make(map[string]bool),
+	}
+}
+
+// Next advances the iterator to the next point. It returns false when there
+// are no more points or an error occurs.
+func (it *DataIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || it.next == "" {
+			return false
+		}
+		it.fetch()
+	}
+	it.point, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Point returns the current point.
+func (it *DataIterator) Point() Point {
+	return it.point
+}
+
+// Err returns the first error encountered by the iterator.
+func (it *DataIterator) Err() error {
+	return it.err
+}
+
+// fetch requests the next page of points.
+func (it *DataIterator) fetch() {
+	// Construct request.
+	req, err := http.NewRequest(http.MethodGet, it.next, nil)
+	if err != nil {
+		it.err = errors.Wrap(err, "could not construct data point API request")
+		return
+	}
+
+	// Send request.
+	res, body, err := it.client.do(req, 0)
+	if err != nil {
+		it.err = errors.Wrap(err, "could not send data point API request")
+		return
+	}
+
+	// Unmarshal response into Point.
+	var points []Point
+	err = json.Unmarshal(body, &points)
+	if err != nil {
+		it.err = errors.Wrap(
+			err, "could not unmarshal response body for data point")
+		return
+	}
+
+	// Drop points that were already returned on the previous page.
+	var fresh []Point
+	for _, point := range points {
+		if it.seen[point.ID] {
+			continue
+		}
+		second := point.CreatedAt.Truncate(time.Second)
+		if second.Before(it.oldest) || it.oldest.IsZero() {
+			it.oldest = second
+			it.seen = make(map[string]bool)
+		}
+		if second.Equal(it.oldest) {
+			it.seen[point.ID] = true
+		}
+		fresh = append(fresh, point)
+
+		// Times are sent to the server at second precision, so filter them
+		// precisely here.
+		if !it.query.Start.IsZero() && point.CreatedAt.Before(it.query.Start) {
+			continue
+		}
+		if !it.query.End.IsZero() && point.CreatedAt.After(it.query.End) {
+			continue
+		}
+		it.page = append(it.page, point)
+	}
+
+	// Find the next page. A page of only points that were already returned
+	// means more points share a second than fit on a page, since pages are
+	// bounded at second precision, so the iterator must get unstuck.
+	switch {
+	case len(fresh) > 0:
+		it.next = nextPage(req.URL, res.Header, points, it.query)
+	case len(points) > 0:
+		it.next = it.unstick(req.URL)
+	default:
+		it.next = ""
+	}
+}
+
+// unstick returns the URL of the page to request after one that held only
+// points that were already returned. The page is requested again at the
+// largest page size, and if it already was, the iterator skips to the second
+// before the oldest point, since the points of that second that did not fit
+// cannot be requested.
+func (it *DataIterator) unstick(current *url.URL) string {
+	u := *current
+	values := u.Query()
+	if limit, _ := strconv.Atoi(values.Get("limit")); limit < MaxPageSize {
+		it.query.Limit = MaxPageSize
+		values.Set("limit", strconv.Itoa(MaxPageSize))
+	} else {
+		end := it.oldest.UTC().Add(-time.Second)
+		if !it.query.Start.IsZero() && end.Before(it.query.Start.UTC().Truncate(time.Second)) {
+			return ""
+		}
+		values.Set("end_time", end.Format(time.RFC3339))
+	}
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// nextPage returns the URL of the page after a response, or an empty string
+// if it is the last page. The next page is taken from the Link header if
+// present, and otherwise derived from the X-Pagination-* headers.
+func nextPage(current *url.URL, header http.Header, points []Point, query DataQuery) string {
+	if link := header.Get(HeaderLink); link != "" {
+		next := parseLink(link, "next")
+		if next == "" {
+			return ""
+		}
+		u, err := current.Parse(next)
+		if err != nil {
+			return ""
+		}
+		return u.String()
+	}
+
+	limit, err := strconv.Atoi(header.Get(HeaderPaginationLimit))
+	if err != nil {
+		return ""
+	}
+	count, err := strconv.Atoi(header.Get(HeaderPaginationCount))
+	if err != nil || count < limit || len(points) == 0 {
+		return ""
+	}
+	if total, err := strconv.Atoi(header.Get(HeaderPaginationTotal)); err == nil && total <= count {
+		return ""
+	}
+
+	// Request points up to the second of the oldest point of this page. The
+	// points of that second are included again, since more of them may not
+	// have fit, and the iterator drops the ones it has returned.
+	query.End = points[len(points)-1].CreatedAt.Truncate(time.Second)
+	u := *current
+	u.RawQuery = query.values().Encode()
+	return u.String()
+}
+
+// parseLink returns the target of the link with relation rel in an RFC 5988
+// Link header, or an empty string if there is none.
+func parseLink(header, rel string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if param == `rel="`+rel+`"` || param == "rel="+rel {
+				return target[1 : len(target)-1]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package adafruitio_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/adafruitio/aiotest"
+)
+
+func TestQueryPages(t *testing.T) {
+	base := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Three points a minute apart, then five within a single second, as a
+	// batch upload can record.
+	var created []time.Time
+	for i := 0; i < 3; i++ {
+		created = append(created, base.Add(time.Duration(i)*time.Minute))
+	}
+	for i := 0; i < 5; i++ {
+		created = append(created, base.Add(3*time.Minute+time.Duration(i)*100*time.Millisecond))
+	}
+
+	for _, test := range []struct {
+		name  string
+		query adafruitio.DataQuery
+		want  []int
+	}{
+		{name: "one page", want: []int{7, 6, 5, 4, 3, 2, 1, 0}},
+		{name: "pages within a second", query: adafruitio.DataQuery{Limit: 2}, want: []int{7, 6, 5, 4, 3, 2, 1, 0}},
+		{name: "pages across seconds", query: adafruitio.DataQuery{Limit: 3}, want: []int{7, 6, 5, 4, 3, 2, 1, 0}},
+		{name: "since", query: adafruitio.DataQuery{Start: base.Add(time.Minute), Limit: 2}, want: []int{7, 6, 5, 4, 3, 2, 1}},
+		{name: "until", query: adafruitio.DataQuery{End: base.Add(time.Minute), Limit: 2}, want: []int{1, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := aiotest.NewServer()
+			defer s.Close()
+			s.AddFeed("fish", "fish", "fish.tank", "tank", true)
+			for i, at := range created {
+				s.AddPoint("fish", "fish.tank", strconv.Itoa(i), at)
+			}
+
+			client := adafruitio.NewPublic(adafruitio.WithBaseURL(s.BaseURL()))
+			it := client.Query("fish", "fish.tank", test.query)
+			var got []int
+			for it.Next() && len(got) <= len(created) {
+				v, err := strconv.Atoi(it.Point().Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v)
+			}
+			if err := it.Err(); err != nil {
+				t.Fatalf("could not query points: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got points %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package adafruitio
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/mqtt"
+)
+
+// DefaultMQTTAddr is the address of the Adafruit.IO MQTT server.
+const DefaultMQTTAddr = "io.adafruit.com:8883"
+
+// A Publisher records data points to Adafruit.IO feeds over MQTT, which is
+// cheaper than one HTTP request per point.
+//
+// Adafruit.IO timestamps MQTT points when they are received, so points that
+// must keep an earlier timestamp should be recorded with Client.Record or
+// Client.RecordBatch instead.
+type Publisher struct {
+	username string
+	client   *mqtt.Client
+	limiter  *limiter
+}
+
+// NewPublisher connects to an Adafruit.IO MQTT server, authenticating with the
+// credentials of an authenticated client. Published points count towards the
+// client's rate limit.
+//
+// If opts.Addr is empty, the publisher connects to DefaultMQTTAddr using TLS.
+// If opts.ClientID is empty, a client ID is derived from the username. Clients
+// with a fixed ID keep their session between connections, unless
+// opts.CleanSession is set.
+func NewPublisher(client *Client, opts mqtt.Options) (*Publisher, error) {
+	conn, err := mqtt.Dial(publisherOptions(client, opts))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to Adafruit.IO MQTT server")
+	}
+	return &Publisher{
+		username: client.username,
+		client:   conn,
+		limiter:  client.limiter,
+	}, nil
+}
+
+// StartPublisher is like NewPublisher, but connects in the background, so that
+// it can be started while the network is down. Check Connected before relying
+// on it.
+func StartPublisher(client *Client, opts mqtt.Options) *Publisher {
+	return &Publisher{
+		username: client.username,
+		client:   mqtt.DialBackground(publisherOptions(client, opts)),
+		limiter:  client.limiter,
+	}
+}
+
+func publisherOptions(client *Client, opts mqtt.Options) mqtt.Options {
+	if opts.Addr == "" {
+		host, _, _ := net.SplitHostPort(DefaultMQTTAddr)
+		opts.Addr = DefaultMQTTAddr
+		opts.TLSConfig = &tls.Config{ServerName: host}
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = DefaultUserAgent + "-" + client.username
+	}
+	opts.Username = client.username
+	opts.Password = client.apiKey
+	return opts
+}
+
+// Topic returns the MQTT topic of a feed.
+func (p *Publisher) Topic(feed string) string {
+	return p.username + "/feeds/" + feed
+}
+
+// Publish records a value to an Adafruit.IO feed. It waits until the server
+// acknowledges the value, or until ctx is done.
+func (p *Publisher) Publish(ctx context.Context, feed, value string) error {
+	if err := p.limiter.WaitContext(ctx, 1); err != nil {
+		return errors.Wrap(err, "could not publish data over MQTT")
+	}
+	err := p.client.Publish(ctx, p.Topic(feed), []byte(value), 1, false)
+	if err != nil {
+		return errors.Wrap(err, "could not publish data over MQTT")
+	}
+	return nil
+}
+
+// Connected reports whether the publisher is currently connected.
+func (p *Publisher) Connected() bool {
+	return p.client.Connected()
+}
+
+// Close disconnects from the MQTT server.
+func (p *Publisher) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,85 @@
+package adafruitio_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/mqtt"
+	"github.com/goodbuns/fishmon/pkg/mqtt/mqtttest"
+)
+
+func TestPublisher(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{name: "published", key: "secret", ok: true},
+		{name: "wrong key", key: "guess"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := mqtttest.NewBroker()
+			if err != nil {
+				t.Fatalf("could not start broker: %s", err)
+			}
+			defer b.Close()
+			b.SetCredentials("fish", "secret")
+
+			client := adafruitio.NewUnchecked("fish", test.key)
+			p, err := adafruitio.NewPublisher(client, mqtt.Options{Addr: b.Addr})
+			if !test.ok {
+				if err == nil {
+					p.Close()
+					t.Fatal("connected with the wrong key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not connect: %s", err)
+			}
+			defer p.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := p.Publish(ctx, "fish.tank", "75.000"); err != nil {
+				t.Fatalf("could not publish: %s", err)
+			}
+			messages := b.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages, want 1", len(messages))
+			}
+			want := mqtttest.Message{
+				ClientID: "fishmon-fish",
+				Topic:    "fish/feeds/fish.tank",
+				Payload:  []byte("75.000"),
+				QoS:      1,
+			}
+			if m := messages[0]; m.ClientID != want.ClientID || m.Topic != want.Topic || string(m.Payload) != string(want.Payload) || m.QoS != want.QoS {
+				t.Errorf("got message %+v, want %+v", m, want)
+			}
+		})
+	}
+}
+
+func TestStartPublisher(t *testing.T) {
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatalf("could not start broker: %s", err)
+	}
+	defer b.Close()
+
+	p := adafruitio.StartPublisher(adafruitio.NewUnchecked("fish", "secret"), mqtt.Options{Addr: b.Addr})
+	defer p.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the publisher to connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.Connects(); len(got) != 1 || got[0].Username != "fish" || got[0].Password != "secret" {
+		t.Errorf("got connections %+v, want one with the client's credentials", got)
+	}
+}
@@ -22,10 +22,15 @@ type Feed struct {
 
 // Feeds retrieves all public feeds of an Adafruit user.
 func Feeds(user string) ([]Feed, error) {
+	return DefaultClient.Feeds(user)
+}
+
+// Feeds retrieves all feeds of an Adafruit user that are visible to the client.
+func (c *Client) Feeds(user string) ([]Feed, error) {
 	// Construct request.
 	req, err := http.NewRequest(
 		http.MethodGet,
-		"https://io.adafruit.com/api/v2/"+user+"/feeds",
+		c.url(user+"/feeds"),
 		nil,
 	)
 	if err != nil {
@@ -33,7 +38,7 @@ func Feeds(user string) ([]Feed, error) {
 	}
 
 	// Send request.
-	res, err := Do(req)
+	res, err := c.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not send feeds API request")
 	}
@@ -49,12 +54,18 @@ func Feeds(user string) ([]Feed, error) {
 	return feeds, nil
 }
 
-// Group retrieves all feeds in a group of an Adafruit user.
+// Group retrieves all public feeds in a group of an Adafruit user.
 func Group(user, group string) ([]Feed, error) {
+	return DefaultClient.Group(user, group)
+}
+
+// Group retrieves all feeds in a group of an Adafruit user that are visible to
+// the client.
+func (c *Client) Group(user, group string) ([]Feed, error) {
 	// Construct request.
 	req, err := http.NewRequest(
 		http.MethodGet,
-		"https://io.adafruit.com/api/v2/"+user+"/groups/"+group+"/feeds",
+		c.url(user+"/groups/"+group+"/feeds"),
 		nil,
 	)
 	if err != nil {
@@ -62,7 +73,7 @@ func Group(user, group string) ([]Feed, error) {
 	}
 
 	// Send request.
-	res, err := Do(req)
+	res, err := c.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not send group feed API request")
 	}
@@ -86,31 +97,31 @@ type Point struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// Data retrieves all points in a feed since a moment in time.
+// Data retrieves all points in a public feed since a moment in time, newest
+// first.
 func Data(user, feed string, since time.Time) ([]Point, error) {
-	// Construct request.
-	req, err := http.NewRequest(
-		http.MethodGet,
-		"https://io.adafruit.com/api/v2/"+user+"/feeds/"+feed+"/data",
-		nil,
-	)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not construct data point API request")
-	}
-
-	// Send request.
-	res, err := Do(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not send data point API request")
-	}
+	return DefaultClient.Data(user, feed, since)
+}
 
-	// Unmarshal response into Point.
+// Data retrieves all points in a feed since a moment in time, newest first.
+func (c *Client) Data(user, feed string, since time.Time) ([]Point, error) {
 	var points []Point
-	err = json.Unmarshal(res, &points)
-	if err != nil {
-		return nil, errors.Wrap(
-			err, "could not unmarshal response body for data point")
+	it := c.Query(user, feed, DataQuery{Start: since, Limit: MaxPageSize})
+	for it.Next() {
+		points = append(points, it.Point())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
 	}
-
 	return points, nil
 }
+
+// Latest retrieves the newest point in a feed. It returns false if the feed has
+// no points.
+func (c *Client) Latest(user, feed string) (Point, bool, error) {
+	it := c.Query(user, feed, DataQuery{Limit: 1})
+	if it.Next() {
+		return it.Point(), true, nil
+	}
+	return Point{}, false, it.Err()
+}
@@ -0,0 +1,164 @@
+package adafruitio
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Tier is an Adafruit.IO account tier, which determines the account's data
+// rate limit.
+type Tier string
+
+// Account tiers.
+const (
+	Free Tier = "free"
+	Plus Tier = "plus"
+)
+
+// RateLimit returns the number of data points per minute that the tier may
+// record, or zero for unknown tiers.
+func (t Tier) RateLimit() int {
+	switch t {
+	case Free:
+		return 30
+	case Plus:
+		return 60
+	default:
+		return 0
+	}
+}
+
+// DefaultThrottleBackoff is how long clients pause after being throttled, when
+// the server does not say how long to wait.
+const DefaultThrottleBackoff = time.Minute
+
+// A ThrottleError is returned when Adafruit.IO rejects a request because the
+// account's rate limit has been exceeded.
+type ThrottleError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("throttled, retry after %s: %s", e.RetryAfter, e.Message)
+}
+
+// retryAfterMessage matches throttle messages that say how long to wait, such
+// as "... Please try again in 42 seconds.".
+var retryAfterMessage = regexp.MustCompile(`(\d+) seconds?`)
+
+// throttleError constructs a ThrottleError from a throttled response.
+func throttleError(res *http.Response, message string) *ThrottleError {
+	wait := DefaultThrottleBackoff
+	if header := res.Header.Get("Retry-After"); header != "" {
+		if seconds, err := strconv.Atoi(header); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if t, err := http.ParseTime(header); err == nil {
+			wait = time.Until(t)
+		}
+	} else if match := retryAfterMessage.FindStringSubmatch(message); match != nil {
+		seconds, _ := strconv.Atoi(match[1])
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	if message == "" {
+		message = http.StatusText(res.StatusCode)
+	}
+	return &ThrottleError{
+		Message:    message,
+		RetryAfter: wait,
+	}
+}
+
+// A limiter is a token bucket that limits the rate at which data points are
+// recorded, and pauses all requests after the client is throttled.
+type limiter struct {
+	mu sync.Mutex
+	// perPoint is the time taken to refill one token. If it is zero, data
+	// points are not rate limited.
+	perPoint time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	paused   time.Time
+}
+
+// newLimiter constructs a limiter that allows perMinute data points per
+// minute, in bursts of up to perMinute points.
+func newLimiter(perMinute int) *limiter {
+	l := &limiter{last: time.Now()}
+	if perMinute > 0 {
+		l.perPoint = time.Minute / time.Duration(perMinute)
+		l.burst = float64(perMinute)
+		l.tokens = l.burst
+	}
+	return l
+}
+
+// Wait blocks until n data points may be recorded. Batches larger than the
+// burst size are allowed, and delay later requests until the bucket refills.
+func (l *limiter) Wait(n int) {
+	l.WaitContext(context.Background(), n)
+}
+
+// WaitContext is like Wait, but gives up when ctx is done, returning its error
+// and putting back the n tokens that it took.
+func (l *limiter) WaitContext(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	wait := l.paused.Sub(now)
+	took := false
+	if l.perPoint > 0 && n > 0 {
+		// Refill the bucket, then take n tokens, going into debt if needed.
+		l.tokens += float64(now.Sub(l.last)) / float64(l.perPoint)
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		l.tokens -= float64(n)
+		took = true
+		if l.tokens < 0 {
+			if debt := time.Duration(-l.tokens * float64(l.perPoint)); debt > wait {
+				wait = debt
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		if took {
+			l.mu.Lock()
+			l.tokens += float64(n)
+			l.mu.Unlock()
+		}
+		return ctx.Err()
+	}
+}
+
+// Pause blocks requests for a duration, and empties the bucket.
+func (l *limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(l.paused) {
+		l.paused = until
+	}
+	if l.tokens > 0 {
+		l.tokens = 0
+	}
+}
@@ -0,0 +1,97 @@
+package adafruitio
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// blockTimeout is how long a wait may take before it is considered blocked.
+const blockTimeout = 50 * time.Millisecond
+
+func TestLimiter(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		perMinute int
+		// taken are the points recorded before waiting.
+		taken  []int
+		pause  time.Duration
+		n      int
+		blocks bool
+	}{
+		{name: "unlimited", taken: []int{1000}, n: 1000},
+		{name: "within burst", perMinute: 60, taken: []int{20, 10}, n: 30},
+		{name: "over burst", perMinute: 60, taken: []int{20, 10}, n: 31, blocks: true},
+		{name: "batch larger than burst", perMinute: 60, n: 61, blocks: true},
+		{name: "requests are not limited", perMinute: 60, taken: []int{60}, n: 0},
+		{name: "paused", perMinute: 60, pause: time.Minute, n: 0, blocks: true},
+		{name: "paused and unlimited", pause: time.Minute, n: 1, blocks: true},
+		{name: "pause over", perMinute: 6000, taken: []int{6000}, pause: -time.Minute, n: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLimiter(test.perMinute)
+			for _, n := range test.taken {
+				l.Wait(n)
+			}
+			if test.pause != 0 {
+				l.Pause(test.pause)
+			}
+			// Refill the bucket for the "pause over" test, whose pause empties it.
+			if test.pause < 0 {
+				time.Sleep(2 * l.perPoint)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), blockTimeout)
+			defer cancel()
+			err := l.WaitContext(ctx, test.n)
+			if blocked := err == context.DeadlineExceeded; blocked != test.blocks {
+				t.Errorf("got blocked %t, want %t", blocked, test.blocks)
+			}
+		})
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := newLimiter(60)
+	l.Wait(60)
+	ctx, cancel := context.WithTimeout(context.Background(), blockTimeout)
+	defer cancel()
+	if err := l.WaitContext(ctx, 10); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The cancelled wait puts back its tokens, so it does not delay later
+	// requests.
+	if l.tokens < -0.5 {
+		t.Errorf("got %.1f tokens after cancelling, want about 0", l.tokens)
+	}
+}
+
+func TestThrottleError(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		header     string
+		message    string
+		retryAfter time.Duration
+	}{
+		{name: "default", message: "slow down", retryAfter: DefaultThrottleBackoff},
+		{name: "header seconds", header: "42", retryAfter: 42 * time.Second},
+		{name: "header date in the past", header: "Wed, 01 Jan 2020 00:00:00 GMT"},
+		{name: "message seconds", message: "Please try again in 30 seconds.", retryAfter: 30 * time.Second},
+		{name: "header over message", header: "5", message: "Please try again in 30 seconds.", retryAfter: 5 * time.Second},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: http.StatusTooManyRequests, Header: make(http.Header)}
+			if test.header != "" {
+				res.Header.Set("Retry-After", test.header)
+			}
+			err := throttleError(res, test.message)
+			if err.RetryAfter != test.retryAfter {
+				t.Errorf("got retry after %s, want %s", err.RetryAfter, test.retryAfter)
+			}
+			if err.Message == "" {
+				t.Errorf("got no message")
+			}
+		})
+	}
+}
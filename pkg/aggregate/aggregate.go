@@ -0,0 +1,199 @@
+// Package aggregate summarizes windows of sensor readings with statistics such
+// as their mean, median and standard deviation, so that sensors can be sampled
+// more often than readings are uploaded.
+package aggregate
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// A Statistic is a summary of the readings in a window.
+type Statistic string
+
+// Statistics.
+const (
+	Mean   Statistic = "mean"
+	Min    Statistic = "min"
+	Max    Statistic = "max"
+	Median Statistic = "median"
+	StdDev Statistic = "stddev"
+	Count  Statistic = "count"
+)
+
+// Statistics lists every statistic.
+var Statistics = []Statistic{Mean, Min, Max, Median, StdDev, Count}
+
+// Known returns whether a statistic is one of Statistics.
+func (s Statistic) Known() bool {
+	for _, stat := range Statistics {
+		if s == stat {
+			return true
+		}
+	}
+	return false
+}
+
+// CountKind is the kind of readings that hold the number of readings in a
+// window.
+const CountKind sensor.Kind = "count"
+
+// A Summary holds the statistics of a sensor's readings in a window.
+type Summary struct {
+	Sensor sensor.ID
+	Kind   sensor.Kind
+	Unit   sensor.Unit
+	// Start and End are the times of the first and last readings.
+	Start time.Time
+	End   time.Time
+
+	Count  int
+	Mean   float64
+	Min    float64
+	Max    float64
+	Median float64
+	StdDev float64
+}
+
+// Value returns a statistic's value.
+func (s Summary) Value(stat Statistic) float64 {
+	switch stat {
+	case Mean:
+		return s.Mean
+	case Min:
+		return s.Min
+	case Max:
+		return s.Max
+	case Median:
+		return s.Median
+	case StdDev:
+		return s.StdDev
+	case Count:
+		return float64(s.Count)
+	default:
+		return math.NaN()
+	}
+}
+
+// Reading returns a statistic as a reading taken at the end of the window.
+// Counts have CountKind and no unit, and standard deviations of temperatures
+// are temperature differences, so that they convert between units correctly.
+func (s Summary) Reading(stat Statistic) sensor.Reading {
+	r := sensor.Reading{
+		Sensor: s.Sensor,
+		Kind:   s.Kind,
+		Value:  s.Value(stat),
+		Unit:   s.Unit,
+		Time:   s.End,
+	}
+	switch {
+	case stat == Count:
+		r.Kind, r.Unit = CountKind, ""
+	case stat == StdDev && s.Kind == sensor.Temperature:
+		r.Kind = sensor.TemperatureDifference
+	}
+	return r
+}
+
+// An Aggregator collects each sensor's readings into a window, and summarizes
+// the windows when flushed. An Aggregator is safe for concurrent use.
+type Aggregator struct {
+	mu      sync.Mutex
+	windows map[sensor.ID]*window
+}
+
+// A window holds the readings of a sensor since the last flush.
+type window struct {
+	kind   sensor.Kind
+	unit   sensor.Unit
+	start  time.Time
+	end    time.Time
+	values []float64
+}
+
+// New constructs an empty aggregator.
+func New() *Aggregator {
+	return &Aggregator{windows: make(map[sensor.ID]*window)}
+}
+
+// Add adds a reading to its sensor's window. Readings in a different unit from
+// the window's earlier readings are converted, or start a new window if they
+// cannot be.
+func (a *Aggregator) Add(r sensor.Reading) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	w, ok := a.windows[r.Sensor]
+	if ok {
+		if converted, err := r.Convert(w.unit); err == nil {
+			r = converted
+		} else {
+			ok = false
+		}
+	}
+	if !ok {
+		w = &window{kind: r.Kind, unit: r.Unit, start: r.Time}
+		a.windows[r.Sensor] = w
+	}
+	if r.Time.Before(w.start) {
+		w.start = r.Time
+	}
+	if r.Time.After(w.end) {
+		w.end = r.Time
+	}
+	w.values = append(w.values, r.Value)
+}
+
+// Flush returns a summary of each sensor with readings since the last flush,
+// ordered by sensor ID, and starts new windows.
+func (a *Aggregator) Flush() []Summary {
+	a.mu.Lock()
+	windows := a.windows
+	a.windows = make(map[sensor.ID]*window)
+	a.mu.Unlock()
+
+	summaries := make([]Summary, 0, len(windows))
+	for id, w := range windows {
+		summaries = append(summaries, w.summarize(id))
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Sensor < summaries[j].Sensor })
+	return summaries
+}
+
+// summarize computes the statistics of a non-empty window.
+func (w *window) summarize(id sensor.ID) Summary {
+	values := append([]float64(nil), w.values...)
+	sort.Float64s(values)
+	n := len(values)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(n)
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	median := values[n/2]
+	if n%2 == 0 {
+		median = (values[n/2-1] + values[n/2]) / 2
+	}
+
+	return Summary{
+		Sensor: id,
+		Kind:   w.kind,
+		Unit:   w.unit,
+		Start:  w.start,
+		End:    w.end,
+		Count:  n,
+		Mean:   mean,
+		Min:    values[0],
+		Max:    values[n-1],
+		Median: median,
+		StdDev: math.Sqrt(squares / float64(n)),
+	}
+}
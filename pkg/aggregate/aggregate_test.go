@@ -0,0 +1,143 @@
+package aggregate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// celsius returns a temperature reading of sensor 28-1, taken seconds after
+// epoch.
+func celsius(seconds int, value float64) sensor.Reading {
+	return sensor.Reading{
+		Sensor: "28-1",
+		Kind:   sensor.Temperature,
+		Value:  value,
+		Unit:   sensor.Celsius,
+		Time:   epoch.Add(time.Duration(seconds) * time.Second),
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		readings []sensor.Reading
+		want     Summary
+	}{
+		{
+			name:     "single reading",
+			readings: []sensor.Reading{celsius(0, 24)},
+			want:     Summary{Count: 1, Mean: 24, Min: 24, Max: 24, Median: 24},
+		},
+		{
+			name:     "odd count",
+			readings: []sensor.Reading{celsius(0, 26), celsius(10, 22), celsius(20, 24)},
+			want:     Summary{End: epoch.Add(20 * time.Second), Count: 3, Mean: 24, Min: 22, Max: 26, Median: 24, StdDev: math.Sqrt(8.0 / 3)},
+		},
+		{
+			name:     "even count",
+			readings: []sensor.Reading{celsius(0, 22), celsius(10, 26), celsius(20, 22), celsius(30, 26)},
+			want:     Summary{End: epoch.Add(30 * time.Second), Count: 4, Mean: 24, Min: 22, Max: 26, Median: 24, StdDev: 2},
+		},
+		{
+			name:     "out of order",
+			readings: []sensor.Reading{celsius(10, 24), celsius(0, 24), celsius(20, 24)},
+			want:     Summary{End: epoch.Add(20 * time.Second), Count: 3, Mean: 24, Min: 24, Max: 24, Median: 24},
+		},
+		{
+			name: "converted",
+			readings: []sensor.Reading{
+				celsius(0, 20),
+				{Sensor: "28-1", Kind: sensor.Temperature, Value: 86, Unit: sensor.Fahrenheit, Time: epoch.Add(10 * time.Second)},
+			},
+			want: Summary{End: epoch.Add(10 * time.Second), Count: 2, Mean: 25, Min: 20, Max: 30, Median: 25, StdDev: 5},
+		},
+		{
+			name: "incompatible unit starts a new window",
+			readings: []sensor.Reading{
+				celsius(0, 20),
+				{Sensor: "28-1", Kind: sensor.PH, Value: 7, Unit: sensor.PHScale, Time: epoch.Add(10 * time.Second)},
+			},
+			want: Summary{Kind: sensor.PH, Unit: sensor.PHScale, Start: epoch.Add(10 * time.Second), End: epoch.Add(10 * time.Second), Count: 1, Mean: 7, Min: 7, Max: 7, Median: 7},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a := New()
+			for _, r := range test.readings {
+				a.Add(r)
+			}
+			summaries := a.Flush()
+			if len(summaries) != 1 {
+				t.Fatalf("got %d summaries, want 1", len(summaries))
+			}
+			want := test.want
+			want.Sensor = "28-1"
+			if want.Kind == "" {
+				want.Kind, want.Unit = sensor.Temperature, sensor.Celsius
+			}
+			if want.Start.IsZero() {
+				want.Start = epoch
+			}
+			if want.End.IsZero() {
+				want.End = epoch
+			}
+			got := summaries[0]
+			if math.Abs(got.StdDev-want.StdDev) < 1e-9 {
+				got.StdDev = want.StdDev
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got summary %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	a := New()
+	a.Add(sensor.Reading{Sensor: "28-2", Kind: sensor.Temperature, Value: 24, Unit: sensor.Celsius, Time: epoch})
+	a.Add(celsius(0, 22))
+	summaries := a.Flush()
+	var ids []sensor.ID
+	for _, s := range summaries {
+		ids = append(ids, s.Sensor)
+	}
+	if want := []sensor.ID{"28-1", "28-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got summaries of %q, want %q", ids, want)
+	}
+
+	// Flushing starts new windows.
+	if summaries := a.Flush(); len(summaries) != 0 {
+		t.Errorf("got %d summaries after flushing, want none", len(summaries))
+	}
+}
+
+func TestSummaryReading(t *testing.T) {
+	s := Summary{
+		Sensor: "28-1",
+		Kind:   sensor.Temperature,
+		Unit:   sensor.Celsius,
+		End:    epoch,
+		Count:  3,
+		Mean:   24,
+		StdDev: 1.5,
+	}
+	for _, test := range []struct {
+		stat Statistic
+		want sensor.Reading
+	}{
+		{stat: Mean, want: sensor.Reading{Sensor: "28-1", Kind: sensor.Temperature, Value: 24, Unit: sensor.Celsius, Time: epoch}},
+		{stat: StdDev, want: sensor.Reading{Sensor: "28-1", Kind: sensor.TemperatureDifference, Value: 1.5, Unit: sensor.Celsius, Time: epoch}},
+		{stat: Count, want: sensor.Reading{Sensor: "28-1", Kind: CountKind, Value: 3, Time: epoch}},
+	} {
+		t.Run(string(test.stat), func(t *testing.T) {
+			if got := s.Reading(test.stat); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got reading %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
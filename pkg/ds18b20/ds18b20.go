@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
 )
 
 // Operating system resource names.
@@ -19,6 +21,7 @@ const (
 	ModW1GPIO       = "w1-gpio"
 	MasterBusPrefix = "w1_bus_master"
 	SensorPrefix    = "28-"
+	SlaveFile       = "w1_slave"
 )
 
 // Sensing errors.
@@ -32,23 +35,49 @@ var (
 )
 
 // ID is a DS18B20 sensor identifier.
-type ID string
+type ID = sensor.ID
+
+// A ModuleLoader loads a kernel module by name.
+type ModuleLoader func(module string) error
+
+// LoadModule loads a kernel module using modprobe.
+func LoadModule(module string) error {
+	return exec.Command(Modprobe, module).Run()
+}
+
+// A Bus is a 1-Wire bus, identified by the sysfs directory containing its
+// device files.
+type Bus struct {
+	// Path is the directory containing the master bus and slave device files.
+	Path string
+	// Load loads the kernel modules required by the bus. If Load is nil, no
+	// modules are loaded.
+	Load ModuleLoader
+}
+
+// DefaultBus is the system 1-Wire bus on a Raspberry Pi.
+var DefaultBus = &Bus{
+	Path: DevicesPath,
+	Load: LoadModule,
+}
 
 // Ensure loads the w1-gpio and w1-therm modules are loaded and checks that the
 // 1-Wire master bus is ready.
-func Ensure() error {
+func (b *Bus) Ensure() error {
 	// Load modules.
-	if err := exec.Command(Modprobe, ModW1GPIO).Run(); err != nil {
-		return errors.Wrap(err, "could not load w1-gpio kernel module")
-	}
-	if err := exec.Command(Modprobe, ModW1Therm).Run(); err != nil {
-		return errors.Wrap(err, "could not load w1-therm kernel module")
+	if b.Load != nil {
+		if err := b.Load(ModW1GPIO); err != nil {
+			return errors.Wrap(err, "could not load w1-gpio kernel module")
+		}
+		if err := b.Load(ModW1Therm); err != nil {
+			return errors.Wrap(err, "could not load w1-therm kernel module")
+		}
 	}
 
 	// Check for master bus device file.
-	devices, err := ioutil.ReadDir(DevicesPath)
+	devices, err := ioutil.ReadDir(b.Path)
 	if err != nil {
-		return errors.Wrapf(err, "could not read 1-Wire devices at %s", DevicesPath)
+		return errors.Wrapf(err, "could not read 1-Wire devices at %s", b.Path)
 	}
 	for _, device := range devices {
 		if strings.HasPrefix(device.Name(), MasterBusPrefix) {
@@ -58,11 +87,11 @@ func Ensure() error {
 	return ErrNoBus
 }
 
-// Sensors returns a listing of available sensor IDs.
-func Sensors() ([]ID, error) {
-	files, err := ioutil.ReadDir(DevicesPath)
+// Sensors returns a listing of available sensor IDs on the bus.
+func (b *Bus) Sensors() ([]ID, error) {
+	files, err := ioutil.ReadDir(b.Path)
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not read 1-Wire devices at %s", DevicesPath)
+		return nil, errors.Wrapf(err, "could not read 1-Wire devices at %s", b.Path)
 	}
 
 	var sensors []ID
@@ -75,3 +104,14 @@ func Sensors() ([]ID, error) {
 	}
 	return sensors, nil
 }
+
+// Ensure loads the w1-gpio and w1-therm modules are loaded and checks that the
+// system 1-Wire master bus is ready.
+func Ensure() error {
+	return DefaultBus.Ensure()
+}
+
+// Sensors returns a listing of available sensor IDs on the system bus.
+func Sensors() ([]ID, error) {
+	return DefaultBus.Sensors()
+}
@@ -0,0 +1,273 @@
+// Package ds18b20test provides a fake 1-Wire sysfs tree for testing code that
+// reads DS18B20 temperature probes without a Raspberry Pi.
+package ds18b20test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+)
+
+// Fake bus layout, relative to the root of a Bus.
+const (
+	DevicesDir = "devices"
+	MasterDir  = "w1_bus_master1"
+	SlavesFile = "w1_master_slaves"
+)
+
+// Scratchpad defaults written by Reading.
+const (
+	DefaultTH     = 0x4b
+	DefaultTL     = 0x46
+	DefaultConfig = 0x7f
+)
+
+// Malformed is w1_slave output that cannot be parsed.
+const Malformed = "00 00 00 00 00 00 00 00 00 : crc=00 YES\n"
+
+// A Bus is a fake 1-Wire bus rooted in a temporary directory. The directory
+// mirrors sysfs: the devices directory contains a w1_bus_master1 symlink and a
+// symlink for each attached probe, pointing into the master directory.
+type Bus struct {
+	// Root is the temporary directory containing the fake bus.
+	Root string
+
+	mu      sync.Mutex
+	modules []string
+	loadErr error
+	probes  []ds18b20.ID
+}
+
+// New constructs an empty fake bus in a new temporary directory.
+func New() (*Bus, error) {
+	root, err := ioutil.TempDir("", "ds18b20test")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create fake bus directory")
+	}
+	b := &Bus{Root: root}
+
+	if err := os.MkdirAll(filepath.Join(root, DevicesDir), 0755); err != nil {
+		b.Close()
+		return nil, errors.Wrap(err, "could not create fake devices directory")
+	}
+	if err := os.MkdirAll(filepath.Join(root, MasterDir), 0755); err != nil {
+		b.Close()
+		return nil, errors.Wrap(err, "could not create fake master bus directory")
+	}
+	err = os.Symlink(filepath.Join("..", MasterDir), filepath.Join(root, DevicesDir, MasterDir))
+	if err != nil {
+		b.Close()
+		return nil, errors.Wrap(err, "could not link fake master bus")
+	}
+	if err := b.writeSlaves(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Bus returns a ds18b20.Bus that reads from the fake bus. Module loads are
+// recorded and can be inspected with Modules.
+func (b *Bus) Bus() *ds18b20.Bus {
+	return &ds18b20.Bus{
+		Path: filepath.Join(b.Root, DevicesDir),
+		Load: b.load,
+	}
+}
+
+func (b *Bus) load(module string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.loadErr != nil {
+		return b.loadErr
+	}
+	b.modules = append(b.modules, module)
+	return nil
+}
+
+// Modules returns the kernel modules that have been loaded through the bus.
+func (b *Bus) Modules() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.modules...)
+}
+
+// FailLoad causes subsequent module loads to fail with err. A nil err allows
+// module loads to succeed again.
+func (b *Bus) FailLoad(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.loadErr = err
+}
+
+// RemoveMaster removes the master bus, as if the w1-gpio overlay is missing.
+func (b *Bus) RemoveMaster() error {
+	err := os.Remove(filepath.Join(b.Root, DevicesDir, MasterDir))
+	if err != nil {
+		return errors.Wrap(err, "could not remove fake master bus link")
+	}
+	return nil
+}
+
+// Add attaches a probe to the bus with initial w1_slave contents.
+func (b *Bus) Add(id ds18b20.ID, contents string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dir := filepath.Join(b.Root, MasterDir, string(id))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "could not create fake probe %s", id)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ds18b20.SlaveFile), []byte(contents), 0644); err != nil {
+		return errors.Wrapf(err, "could not write fake probe %s", id)
+	}
+	link := filepath.Join(b.Root, DevicesDir, string(id))
+	if err := os.Symlink(filepath.Join("..", MasterDir, string(id)), link); err != nil && !os.IsExist(err) {
+		return errors.Wrapf(err, "could not link fake probe %s", id)
+	}
+
+	for _, probe := range b.probes {
+		if probe == id {
+			return nil
+		}
+	}
+	b.probes = append(b.probes, id)
+	return b.writeSlaves()
+}
+
+// Set replaces the w1_slave contents of an attached probe. Open probes see the
+// new contents on their next read.
+func (b *Bus) Set(id ds18b20.ID, contents string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	filename := filepath.Join(b.Root, MasterDir, string(id), ds18b20.SlaveFile)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not open fake probe %s", id)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		return errors.Wrapf(err, "could not write fake probe %s", id)
+	}
+	return nil
+}
+
+// SetAttribute writes a sysfs attribute of an attached probe, such as
+// ds18b20.TemperatureFile or ds18b20.ResolutionFile, as newer w1_therm drivers
+// expose alongside w1_slave. Probes look for the temperature attribute when they
+// are opened.
+func (b *Bus) SetAttribute(id ds18b20.ID, name, contents string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	filename := filepath.Join(b.Root, MasterDir, string(id), name)
+	if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+		return errors.Wrapf(err, "could not write fake probe %s attribute %s", id, name)
+	}
+	return nil
+}
+
+// Remove detaches a probe from the bus. Probes that still hold the device file
+// open read empty output, which fails to parse, as a vanished device would.
+func (b *Bus) Remove(id ds18b20.ID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	dir := filepath.Join(b.Root, MasterDir, string(id))
+	if err := ioutil.WriteFile(filepath.Join(dir, ds18b20.SlaveFile), nil, 0644); err != nil {
+		return errors.Wrapf(err, "could not clear fake probe %s", id)
+	}
+	if err := os.Remove(filepath.Join(b.Root, DevicesDir, string(id))); err != nil {
+		return errors.Wrapf(err, "could not unlink fake probe %s", id)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "could not remove fake probe %s", id)
+	}
+
+	for i, probe := range b.probes {
+		if probe == id {
+			b.probes = append(b.probes[:i], b.probes[i+1:]...)
+			break
+		}
+	}
+	return b.writeSlaves()
+}
+
+// writeSlaves updates the master bus slave listing. It must be called with the
+// lock held.
+func (b *Bus) writeSlaves() error {
+	var listing string
+	for _, probe := range b.probes {
+		listing += string(probe) + "\n"
+	}
+	if len(b.probes) == 0 {
+		listing = "not found.\n"
+	}
+	err := ioutil.WriteFile(filepath.Join(b.Root, MasterDir, SlavesFile), []byte(listing), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write fake slave listing")
+	}
+	return nil
+}
+
+// Close removes the fake bus directory.
+func (b *Bus) Close() error {
+	if err := os.RemoveAll(b.Root); err != nil {
+		return errors.Wrap(err, "could not remove fake bus directory")
+	}
+	return nil
+}
+
+// Reading returns w1_slave output for a successful reading of temperature t,
+// in degrees Celsius, at 12-bit resolution.
+func Reading(t float64) string {
+	return format(scratchpad(t), true, t)
+}
+
+// CRCError returns w1_slave output for a reading of temperature t that failed
+// its CRC check.
+func CRCError(t float64) string {
+	pad := scratchpad(t)
+	pad[8] ^= 0xff
+	return format(pad, false, t)
+}
+
+// scratchpad encodes temperature t into a DS18B20 scratchpad.
+func scratchpad(t float64) [9]byte {
+	raw := int16(math.Round(t * 16))
+	pad := [9]byte{
+		byte(raw), byte(uint16(raw) >> 8),
+		DefaultTH, DefaultTL, DefaultConfig,
+		0xff, 0x0c, 0x10,
+	}
+	pad[8] = CRC8(pad[:8])
+	return pad
+}
+
+func format(pad [9]byte, ok bool, t float64) string {
+	hex := make([]string, len(pad))
+	for i, b := range pad {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	bytes := strings.Join(hex, " ")
+	status := "NO"
+	if ok {
+		status = "YES"
+	}
+	return fmt.Sprintf("%s : crc=%02x %s\n%s t=%d\n",
+		bytes, pad[8], status, bytes, int(math.Round(t*16))*1000/16)
+}
+
+// CRC8 computes the Dallas/Maxim 1-Wire CRC of data.
+func CRC8(data []byte) byte {
+	return ds18b20.CRC8(data)
+}
@@ -0,0 +1,136 @@
+package ds18b20
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sentinel temperatures that DS18B20 probes report instead of a reading.
+const (
+	// PowerOnResetTemperature is the value of the temperature register
+	// before the probe's first conversion, which it reports after a brownout
+	// or when read too early.
+	PowerOnResetTemperature = Temperature(85)
+	// DisconnectedTemperature is reported by the w1-therm driver when the
+	// probe stops responding partway through a read.
+	DisconnectedTemperature = Temperature(-127)
+)
+
+// StepConfirmations is how many readings in a row must agree with a rejected
+// step change before the filter accepts that the temperature really changed.
+const StepConfirmations = 3
+
+// Filtering errors.
+var (
+	ErrPowerOnReset    = errors.New("power-on reset value")
+	ErrDisconnected    = errors.New("disconnected probe value")
+	ErrImplausibleStep = errors.New("implausible temperature step")
+)
+
+// A Filter rejects glitched readings from a single probe: the sentinel values
+// that probes report instead of a temperature, and changes that are too fast
+// to be real. The readings it accepts are despiked by taking the median of the
+// last few. A Filter is not safe for concurrent use.
+type Filter struct {
+	// MaxRate is the fastest plausible change in temperature, in °C per
+	// second. If zero, changes are not checked.
+	MaxRate float64
+	// Window is the number of accepted readings that the median is taken
+	// over. If less than 2, readings are not despiked.
+	Window int
+
+	samples []sample
+	steps   []sample
+}
+
+// A sample is an accepted temperature and the time it was read.
+type sample struct {
+	temperature Temperature
+	at          time.Time
+}
+
+// NewFilter constructs a filter with no history.
+func NewFilter(maxRate float64, window int) *Filter {
+	return &Filter{MaxRate: maxRate, Window: window}
+}
+
+// Filter checks a temperature read at a time, and returns the despiked
+// temperature, or an error wrapping ErrPowerOnReset, ErrDisconnected or
+// ErrImplausibleStep if the reading is rejected.
+func (f *Filter) Filter(t Temperature, at time.Time) (Temperature, error) {
+	switch t {
+	case PowerOnResetTemperature:
+		return ImpossibleTemperature, ErrPowerOnReset
+	case DisconnectedTemperature:
+		return ImpossibleTemperature, ErrDisconnected
+	}
+
+	if n := len(f.samples); n > 0 && f.MaxRate > 0 {
+		last := f.samples[n-1]
+		step := math.Abs(float64(t - last.temperature))
+		if elapsed := at.Sub(last.at).Seconds(); step > f.MaxRate*elapsed {
+			f.steps = append(f.steps, sample{temperature: t, at: at})
+			if !f.confirmed() {
+				return ImpossibleTemperature, errors.Wrapf(ErrImplausibleStep, "%s to %s in %.1fs", last.temperature, t, elapsed)
+			}
+			// The temperature really changed, so restart from the readings
+			// that confirmed it.
+			f.samples = append(f.samples[:0], f.steps...)
+			f.steps = nil
+			return f.median(), nil
+		}
+	}
+	f.steps = nil
+
+	f.samples = append(f.samples, sample{temperature: t, at: at})
+	return f.median(), nil
+}
+
+// Reset clears the filter's history, such as after a probe is reseated.
+func (f *Filter) Reset() {
+	f.samples = nil
+	f.steps = nil
+}
+
+// confirmed returns whether the last StepConfirmations rejected steps agree
+// with each other.
+func (f *Filter) confirmed() bool {
+	if len(f.steps) < StepConfirmations {
+		return false
+	}
+	f.steps = f.steps[len(f.steps)-StepConfirmations:]
+	for i := 1; i < len(f.steps); i++ {
+		prev, next := f.steps[i-1], f.steps[i]
+		step := math.Abs(float64(next.temperature - prev.temperature))
+		if step > f.MaxRate*next.at.Sub(prev.at).Seconds() {
+			return false
+		}
+	}
+	return true
+}
+
+// median trims the history to the window, and returns the median of the
+// accepted temperatures in it.
+func (f *Filter) median() Temperature {
+	window := f.Window
+	if window < 1 {
+		window = 1
+	}
+	if len(f.samples) > window {
+		f.samples = append(f.samples[:0], f.samples[len(f.samples)-window:]...)
+	}
+
+	temperatures := make([]float64, len(f.samples))
+	for i, s := range f.samples {
+		temperatures[i] = float64(s.temperature)
+	}
+	sort.Float64s(temperatures)
+	n := len(temperatures)
+	if n%2 == 0 {
+		return Temperature((temperatures[n/2-1] + temperatures[n/2]) / 2)
+	}
+	return Temperature(temperatures[n/2])
+}
@@ -0,0 +1,137 @@
+package ds18b20_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+)
+
+// A filtered is a reading given to a filter, and what the filter should
+// return.
+type filtered struct {
+	// seconds is when the reading is taken.
+	seconds int
+	t       ds18b20.Temperature
+	want    ds18b20.Temperature
+	err     error
+}
+
+func TestFilter(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		maxRate  float64
+		window   int
+		readings []filtered
+	}{
+		{
+			name: "power-on reset",
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: ds18b20.PowerOnResetTemperature, err: ds18b20.ErrPowerOnReset},
+				{seconds: 20, t: 24.5, want: 24.5},
+			},
+		},
+		{
+			name: "disconnected",
+			readings: []filtered{
+				{seconds: 0, t: ds18b20.DisconnectedTemperature, err: ds18b20.ErrDisconnected},
+			},
+		},
+		{
+			name:   "not despiked",
+			window: 1,
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: 30, want: 30},
+			},
+		},
+		{
+			name:   "median",
+			window: 3,
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: 24.5, want: 24.25},
+				{seconds: 20, t: 30, want: 24.5},
+				{seconds: 30, t: 24, want: 24.5},
+				{seconds: 40, t: 24, want: 24},
+			},
+		},
+		{
+			name:    "implausible step",
+			maxRate: 0.1,
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: 30, err: ds18b20.ErrImplausibleStep},
+				{seconds: 20, t: 24.5, want: 24.5},
+			},
+		},
+		{
+			name:    "gradual change",
+			maxRate: 0.1,
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: 25, want: 25},
+				{seconds: 20, t: 26, want: 26},
+			},
+		},
+		{
+			name:    "confirmed step",
+			maxRate: 0.1,
+			window:  3,
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: 30, err: ds18b20.ErrImplausibleStep},
+				{seconds: 20, t: 30.25, err: ds18b20.ErrImplausibleStep},
+				// The median restarts from the readings that confirmed
+				// the step.
+				{seconds: 30, t: 30.5, want: 30.25},
+			},
+		},
+		{
+			name:    "unconfirmed steps",
+			maxRate: 0.1,
+			readings: []filtered{
+				{seconds: 0, t: 24, want: 24},
+				{seconds: 10, t: 30, err: ds18b20.ErrImplausibleStep},
+				{seconds: 20, t: 18, err: ds18b20.ErrImplausibleStep},
+				{seconds: 30, t: 30, err: ds18b20.ErrImplausibleStep},
+				{seconds: 40, t: 24, want: 24},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := ds18b20.NewFilter(test.maxRate, test.window)
+			epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i, r := range test.readings {
+				got, err := f.Filter(r.t, epoch.Add(time.Duration(r.seconds)*time.Second))
+				if errors.Cause(err) != r.err {
+					t.Fatalf("reading %d: got error %v, want %v", i, err, r.err)
+				}
+				if err == nil && math.Abs(float64(got-r.want)) > 1e-9 {
+					t.Errorf("reading %d: got %s, want %s", i, got, r.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterReset(t *testing.T) {
+	f := ds18b20.NewFilter(0.1, 3)
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := f.Filter(24, epoch); err != nil {
+		t.Fatal(err)
+	}
+	f.Reset()
+	// After a reset, there is no previous reading to check the step from.
+	got, err := f.Filter(30, epoch.Add(time.Second))
+	if err != nil {
+		t.Fatalf("got error %v after resetting, want none", err)
+	}
+	if got != 30 {
+		t.Errorf("got %s, want 30", got)
+	}
+}
@@ -1,50 +1,122 @@
 package ds18b20
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
 )
 
 // Verify interfaces.
 var (
-	_ io.Closer = &Probe{}
+	_ io.Closer     = &Probe{}
+	_ sensor.Sensor = &Probe{}
 )
 
 // A Probe represents a single DS18B20 sensor attached to the W1 master bus.
 type Probe struct {
-	ID ID
-
-	fd *os.File
+	id   ID
+	dir  string
+	path string
+	fd   *os.File
+	// attribute is whether the driver exposes the temperature attribute.
+	attribute bool
 }
 
-// New constructs a new probe by opening the corresponding device file.
+// New constructs a new probe on the system bus by opening the corresponding
+// device file.
 func New(id ID) (*Probe, error) {
-	fd, err := os.Open(filepath.Join(filepath.Join(DevicesPath, string(id)), "w1_slave"))
+	return DefaultBus.Open(id)
+}
+
+// Open constructs a new probe on the bus by opening the corresponding device
+// file.
+func (b *Bus) Open(id ID) (*Probe, error) {
+	dir := filepath.Join(b.Path, string(id))
+	path := filepath.Join(dir, SlaveFile)
+	fd, err := os.Open(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not open sensor device file")
 	}
-	return &Probe{
-		ID: id,
-		fd: fd,
+	p := &Probe{
+		id:   id,
+		dir:  dir,
+		path: path,
+		fd:   fd,
+	}
+	p.attribute = p.hasAttribute(TemperatureFile)
+	return p, nil
+}
+
+// ID returns the probe's device identifier.
+func (p *Probe) ID() ID {
+	return p.id
+}
+
+// Kind returns sensor.Temperature.
+func (p *Probe) Kind() sensor.Kind {
+	return sensor.Temperature
+}
+
+// Unit returns sensor.Celsius.
+func (p *Probe) Unit() sensor.Unit {
+	return sensor.Celsius
+}
+
+// Read reads the probe's temperature as a sensor reading.
+func (p *Probe) Read(ctx context.Context) (sensor.Reading, error) {
+	if err := ctx.Err(); err != nil {
+		return sensor.Reading{}, err
+	}
+	timestamp := time.Now()
+	temperature, err := p.Sense()
+	if err != nil {
+		return sensor.Reading{}, err
+	}
+	return sensor.Reading{
+		Sensor: p.id,
+		Kind:   sensor.Temperature,
+		Value:  float64(temperature.Celsius()),
+		Unit:   sensor.Celsius,
+		Time:   timestamp,
 	}, nil
 }
 
-// Sense reads the probe's temperature.
+// Sense reads the probe's temperature. The temperature attribute is preferred
+// if the driver has one, since it skips formatting the scratchpad, and w1_slave
+// is parsed otherwise or if reading the attribute fails.
 func (p *Probe) Sense() (Temperature, error) {
-	// Re-seek to the beginning of the file to signal the hardware device to send
-	// a new reading.
-	if _, err := p.fd.Seek(0, 0); err != nil {
-		return ImpossibleTemperature, errors.Wrap(err, "could not seek sensor device file")
+	if p.attribute {
+		if t, err := p.senseAttribute(); err == nil {
+			return t, nil
+		}
 	}
-	reading, err := ioutil.ReadAll(p.fd)
-	if err != nil {
-		return ImpossibleTemperature, errors.Wrap(err, "could not read sensor device file")
+	return p.senseSlave()
+}
+
+// senseSlave reads the probe's temperature from w1_slave.
+func (p *Probe) senseSlave() (Temperature, error) {
+	reading, err := p.read()
+	if err != nil || len(reading) == 0 {
+		// The device file goes stale when the probe is detached or reseated,
+		// so reopen it and try again.
+		if reopenErr := p.reopen(); reopenErr != nil {
+			if err == nil {
+				err = ErrInvalidOutput
+			}
+			return ImpossibleTemperature, err
+		}
+		if reading, err = p.read(); err != nil {
+			return ImpossibleTemperature, err
+		}
 	}
 
 	lines := strings.Split(strings.TrimSpace(string(reading)), "\n")
@@ -70,6 +142,31 @@ func (p *Probe) Sense() (Temperature, error) {
 	return Temperature(float32(temp) / 1000.0), nil
 }
 
+// read reads the device file.
+func (p *Probe) read() ([]byte, error) {
+	// Re-seek to the beginning of the file to signal the hardware device to send
+	// a new reading.
+	if _, err := p.fd.Seek(0, 0); err != nil {
+		return nil, errors.Wrap(err, "could not seek sensor device file")
+	}
+	reading, err := ioutil.ReadAll(p.fd)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read sensor device file")
+	}
+	return reading, nil
+}
+
+// reopen replaces the probe's device file descriptor.
+func (p *Probe) reopen() error {
+	fd, err := os.Open(p.path)
+	if err != nil {
+		return errors.Wrap(err, "could not reopen sensor device file")
+	}
+	p.fd.Close()
+	p.fd = fd
+	return nil
+}
+
 // Close the underlying device file for this probe.
 func (p *Probe) Close() error {
 	err := p.fd.Close()
@@ -0,0 +1,107 @@
+package ds18b20_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/ds18b20/ds18b20test"
+)
+
+const id = ds18b20.ID("28-02089245bf26")
+
+func TestSense(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// setup scripts the probe after it is opened.
+		setup func(fake *ds18b20test.Bus) error
+		want  ds18b20.Temperature
+		err   error
+	}{
+		{
+			name:  "reading",
+			setup: func(fake *ds18b20test.Bus) error { return nil },
+			want:  24.5,
+		},
+		{
+			name:  "below freezing",
+			setup: func(fake *ds18b20test.Bus) error { return fake.Set(id, ds18b20test.Reading(-3.25)) },
+			want:  -3.25,
+		},
+		{
+			name:  "CRC error",
+			setup: func(fake *ds18b20test.Bus) error { return fake.Set(id, ds18b20test.CRCError(24.5)) },
+			err:   ds18b20.ErrCRC,
+		},
+		{
+			name:  "malformed",
+			setup: func(fake *ds18b20test.Bus) error { return fake.Set(id, ds18b20test.Malformed) },
+			err:   ds18b20.ErrInvalidOutput,
+		},
+		{
+			name:  "detached",
+			setup: func(fake *ds18b20test.Bus) error { return fake.Remove(id) },
+			err:   ds18b20.ErrInvalidOutput,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake, err := ds18b20test.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fake.Close()
+			if err := fake.Add(id, ds18b20test.Reading(24.5)); err != nil {
+				t.Fatal(err)
+			}
+			probe, err := fake.Bus().Open(id)
+			if err != nil {
+				t.Fatalf("could not open probe: %s", err)
+			}
+			defer probe.Close()
+			if err := test.setup(fake); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := probe.Sense()
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	fake, err := ds18b20test.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	if err := fake.Add(id, ds18b20test.Reading(24.5)); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := fake.Bus()
+	if err := bus.Ensure(); err != nil {
+		t.Fatalf("could not ensure bus: %s", err)
+	}
+	if got, want := fake.Modules(), []string{ds18b20.ModW1GPIO, ds18b20.ModW1Therm}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got modules %q, want %q", got, want)
+	}
+	sensors, err := bus.Sensors()
+	if err != nil {
+		t.Fatalf("could not list sensors: %s", err)
+	}
+	if want := []ds18b20.ID{id}; !reflect.DeepEqual(sensors, want) {
+		t.Errorf("got sensors %q, want %q", sensors, want)
+	}
+
+	if err := fake.RemoveMaster(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Ensure(); err != ds18b20.ErrNoBus {
+		t.Errorf("got error %v without a master bus, want %v", err, ds18b20.ErrNoBus)
+	}
+}
@@ -0,0 +1,121 @@
+package ds18b20
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sysfs attributes exposed by newer w1_therm kernel drivers alongside
+// w1_slave.
+const (
+	ResolutionFile  = "resolution"
+	ConvTimeFile    = "conv_time"
+	TemperatureFile = "temperature"
+)
+
+// Resolutions supported by the DS18B20, in bits.
+const (
+	MinResolution = 9
+	MaxResolution = 12
+)
+
+// MaxConversionTime is the time a DS18B20 takes to convert a temperature at
+// MaxResolution. Each bit less of resolution halves it.
+const MaxConversionTime = 750 * time.Millisecond
+
+// Resolution errors.
+var (
+	ErrUnsupported       = errors.New("not supported by the w1_therm driver")
+	ErrInvalidResolution = errors.New("resolution must be between 9 and 12 bits")
+)
+
+// ConversionTime returns the datasheet conversion time at a resolution.
+func ConversionTime(bits int) time.Duration {
+	return MaxConversionTime >> uint(MaxResolution-bits)
+}
+
+// Resolution returns the probe's resolution in bits. It is read from the
+// resolution attribute if the driver has one, and from the configuration
+// register in the probe's scratchpad otherwise. It opens its own device file,
+// so it can be called while the probe is being read.
+func (p *Probe) Resolution() (int, error) {
+	if bits, err := p.readAttribute(ResolutionFile); err == nil {
+		return bits, nil
+	}
+	reading, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not read sensor device file")
+	}
+	pad, err := ParseScratchpad(string(reading))
+	if err != nil {
+		return 0, err
+	}
+	return pad.Resolution(), nil
+}
+
+// SetResolution sets the probe's resolution in bits. It requires a driver with
+// a writable resolution attribute, and returns ErrUnsupported otherwise.
+func (p *Probe) SetResolution(bits int) error {
+	if bits < MinResolution || bits > MaxResolution {
+		return errors.Wrapf(ErrInvalidResolution, "%d", bits)
+	}
+	if !p.hasAttribute(ResolutionFile) {
+		return errors.Wrap(ErrUnsupported, "could not set resolution")
+	}
+	err := ioutil.WriteFile(filepath.Join(p.dir, ResolutionFile), []byte(strconv.Itoa(bits)+"\n"), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not set resolution")
+	}
+	return nil
+}
+
+// ConversionTime returns how long the probe takes to convert a temperature. It
+// is read from the conv_time attribute if the driver has one, and is the
+// datasheet time for the probe's resolution otherwise. Like Resolution, it can
+// be called while the probe is being read.
+func (p *Probe) ConversionTime() (time.Duration, error) {
+	if ms, err := p.readAttribute(ConvTimeFile); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	bits, err := p.Resolution()
+	if err != nil {
+		return 0, err
+	}
+	return ConversionTime(bits), nil
+}
+
+// senseAttribute reads the probe's temperature from the temperature attribute,
+// which holds millidegrees Celsius. The driver checks the CRC itself, and fails
+// the read if it does not match.
+func (p *Probe) senseAttribute() (Temperature, error) {
+	millis, err := p.readAttribute(TemperatureFile)
+	if err != nil {
+		return ImpossibleTemperature, err
+	}
+	return Temperature(float32(millis) / 1000.0), nil
+}
+
+// readAttribute reads an integer sysfs attribute of the probe.
+func (p *Probe) readAttribute(name string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(p.dir, name))
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not read %s attribute", name)
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse %s attribute", name)
+	}
+	return value, nil
+}
+
+// hasAttribute returns whether the probe's driver exposes an attribute.
+func (p *Probe) hasAttribute(name string) bool {
+	_, err := os.Stat(filepath.Join(p.dir, name))
+	return err == nil
+}
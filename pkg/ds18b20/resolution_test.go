@@ -0,0 +1,153 @@
+package ds18b20_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+	"github.com/goodbuns/fishmon/pkg/ds18b20/ds18b20test"
+)
+
+// openProbe attaches a probe with sysfs attributes to a fake bus and opens it.
+func openProbe(t *testing.T, attributes map[string]string) (*ds18b20test.Bus, *ds18b20.Probe) {
+	t.Helper()
+	fake, err := ds18b20test.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Add(id, ds18b20test.Reading(24.5)); err != nil {
+		fake.Close()
+		t.Fatal(err)
+	}
+	for name, contents := range attributes {
+		if err := fake.SetAttribute(id, name, contents); err != nil {
+			fake.Close()
+			t.Fatal(err)
+		}
+	}
+	probe, err := fake.Bus().Open(id)
+	if err != nil {
+		fake.Close()
+		t.Fatalf("could not open probe: %s", err)
+	}
+	return fake, probe
+}
+
+func TestConversionTime(t *testing.T) {
+	for _, test := range []struct {
+		bits int
+		want time.Duration
+	}{
+		{bits: 9, want: 93750 * time.Microsecond},
+		{bits: 10, want: 187500 * time.Microsecond},
+		{bits: 11, want: 375 * time.Millisecond},
+		{bits: 12, want: 750 * time.Millisecond},
+	} {
+		if got := ds18b20.ConversionTime(test.bits); got != test.want {
+			t.Errorf("got %s at %d bits, want %s", got, test.bits, test.want)
+		}
+	}
+}
+
+func TestResolution(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		attributes map[string]string
+		bits       int
+		convTime   time.Duration
+	}{
+		{
+			// The fake probe's configuration register is set to 12 bits.
+			name:     "scratchpad",
+			bits:     12,
+			convTime: 750 * time.Millisecond,
+		},
+		{
+			name:       "resolution attribute",
+			attributes: map[string]string{ds18b20.ResolutionFile: "10\n"},
+			bits:       10,
+			convTime:   187500 * time.Microsecond,
+		},
+		{
+			name:       "conv_time attribute",
+			attributes: map[string]string{ds18b20.ResolutionFile: "10\n", ds18b20.ConvTimeFile: "200\n"},
+			bits:       10,
+			convTime:   200 * time.Millisecond,
+		},
+		{
+			name:       "unreadable resolution attribute",
+			attributes: map[string]string{ds18b20.ResolutionFile: "ten\n"},
+			bits:       12,
+			convTime:   750 * time.Millisecond,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake, probe := openProbe(t, test.attributes)
+			defer fake.Close()
+			defer probe.Close()
+
+			bits, err := probe.Resolution()
+			if err != nil {
+				t.Fatalf("could not read resolution: %s", err)
+			}
+			if bits != test.bits {
+				t.Errorf("got resolution %d, want %d", bits, test.bits)
+			}
+			convTime, err := probe.ConversionTime()
+			if err != nil {
+				t.Fatalf("could not read conversion time: %s", err)
+			}
+			if convTime != test.convTime {
+				t.Errorf("got conversion time %s, want %s", convTime, test.convTime)
+			}
+		})
+	}
+}
+
+func TestSetResolution(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// writable is whether the driver has a resolution attribute.
+		writable bool
+		bits     int
+		err      error
+	}{
+		{name: "set", writable: true, bits: 9},
+		{name: "unsupported", bits: 9, err: ds18b20.ErrUnsupported},
+		{name: "too low", writable: true, bits: 8, err: ds18b20.ErrInvalidResolution},
+		{name: "too high", writable: true, bits: 13, err: ds18b20.ErrInvalidResolution},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attributes := map[string]string{}
+			if test.writable {
+				attributes[ds18b20.ResolutionFile] = "12\n"
+			}
+			fake, probe := openProbe(t, attributes)
+			defer fake.Close()
+			defer probe.Close()
+
+			err := probe.SetResolution(test.bits)
+			if errors.Cause(err) != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if !test.writable {
+				return
+			}
+			data, err := ioutil.ReadFile(filepath.Join(fake.Root, ds18b20test.MasterDir, string(id), ds18b20.ResolutionFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "12\n"
+			if test.err == nil {
+				want = "9\n"
+			}
+			if string(data) != want {
+				t.Errorf("got resolution attribute %q, want %q", data, want)
+			}
+		})
+	}
+}
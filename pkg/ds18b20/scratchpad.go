@@ -0,0 +1,262 @@
+package ds18b20
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Sysfs attributes for power and alarm settings, exposed by newer w1_therm
+// kernel drivers.
+const (
+	ExtPowerFile = "ext_power"
+	AlarmsFile   = "alarms"
+)
+
+// Alarm threshold limits, in degrees Celsius. These are the limits of the
+// DS18B20's measurement range.
+const (
+	MinAlarm = -55
+	MaxAlarm = 125
+)
+
+// Scratchpad errors.
+var (
+	ErrInvalidAlarms = errors.New("alarm thresholds must be between -55°C and 125°C, with low at most high")
+)
+
+// ScratchpadSize is the number of bytes in a DS18B20 scratchpad.
+const ScratchpadSize = 9
+
+// A Scratchpad is the contents of a DS18B20's scratchpad memory, as echoed on
+// the first line of w1_slave output.
+type Scratchpad struct {
+	// RawTemperature is the last converted temperature, in sixteenths of a
+	// degree Celsius. Bits below the resolution are undefined.
+	RawTemperature int16
+	// TH and TL are the high and low alarm thresholds, in degrees Celsius.
+	TH int8
+	TL int8
+	// Config is the configuration register, which holds the resolution.
+	Config byte
+	// Reserved holds the reserved bytes.
+	Reserved [3]byte
+	// CRC is the CRC of the other bytes, as sent by the probe.
+	CRC byte
+}
+
+// ParseScratchpad parses the scratchpad bytes on the first line of w1_slave
+// output, such as "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES". It does not check
+// the CRC.
+func ParseScratchpad(line string) (Scratchpad, error) {
+	line = strings.SplitN(line, "\n", 2)[0]
+	if idx := strings.Index(line, ":"); idx != -1 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) != ScratchpadSize {
+		return Scratchpad{}, ErrInvalidOutput
+	}
+	var data [ScratchpadSize]byte
+	for i, field := range fields {
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return Scratchpad{}, ErrInvalidOutput
+		}
+		data[i] = byte(b)
+	}
+	return Scratchpad{
+		RawTemperature: int16(uint16(data[0]) | uint16(data[1])<<8),
+		TH:             int8(data[2]),
+		TL:             int8(data[3]),
+		Config:         data[4],
+		Reserved:       [3]byte{data[5], data[6], data[7]},
+		CRC:            data[8],
+	}, nil
+}
+
+// Bytes returns the scratchpad's bytes in the order the probe sends them.
+func (s Scratchpad) Bytes() [ScratchpadSize]byte {
+	return [ScratchpadSize]byte{
+		byte(s.RawTemperature), byte(uint16(s.RawTemperature) >> 8),
+		byte(s.TH), byte(s.TL), s.Config,
+		s.Reserved[0], s.Reserved[1], s.Reserved[2],
+		s.CRC,
+	}
+}
+
+// Valid returns whether the scratchpad's CRC matches its contents. An invalid
+// scratchpad usually means noise on the bus, or a loose data wire.
+func (s Scratchpad) Valid() bool {
+	data := s.Bytes()
+	return CRC8(data[:ScratchpadSize-1]) == s.CRC
+}
+
+// Resolution returns the resolution set in the configuration register, in
+// bits.
+func (s Scratchpad) Resolution() int {
+	return MinResolution + int(s.Config>>5&0x3)
+}
+
+// Temperature returns the last converted temperature, ignoring bits below the
+// resolution.
+func (s Scratchpad) Temperature() Temperature {
+	undefined := int16(1)<<uint(MaxResolution-s.Resolution()) - 1
+	return Temperature(float32(s.RawTemperature&^undefined) / 16)
+}
+
+// Alarms returns the alarm thresholds.
+func (s Scratchpad) Alarms() Alarms {
+	return Alarms{Low: int(s.TL), High: int(s.TH)}
+}
+
+// Alarmed returns whether the last converted temperature is outside of the
+// alarm thresholds, which is when the probe answers an alarm search.
+func (s Scratchpad) Alarmed() bool {
+	t := s.Temperature()
+	return t <= Temperature(s.TL) || t >= Temperature(s.TH)
+}
+
+func (s Scratchpad) String() string {
+	data := s.Bytes()
+	hex := make([]string, len(data))
+	for i, b := range data {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, " ")
+}
+
+// CRC8 computes the Dallas/Maxim 1-Wire CRC of data.
+func CRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			mix := (crc ^ b) & 0x01
+			crc >>= 1
+			if mix != 0 {
+				crc ^= 0x8c
+			}
+			b >>= 1
+		}
+	}
+	return crc
+}
+
+// Alarms are a probe's on-chip alarm thresholds, in whole degrees Celsius,
+// held in its TL and TH registers.
+type Alarms struct {
+	Low  int
+	High int
+}
+
+// Validate checks that the thresholds are in range and in order.
+func (a Alarms) Validate() error {
+	if a.Low < MinAlarm || a.High > MaxAlarm || a.Low > a.High {
+		return errors.Wrapf(ErrInvalidAlarms, "low %d°C, high %d°C", a.Low, a.High)
+	}
+	return nil
+}
+
+// A PowerMode is how a probe is powered.
+type PowerMode int
+
+// Power modes.
+const (
+	// External probes are powered through their VDD pin.
+	External PowerMode = iota + 1
+	// Parasite probes draw power from the data line, which needs a strong
+	// pull-up during conversions, and is prone to failed reads on long
+	// cables.
+	Parasite
+)
+
+func (m PowerMode) String() string {
+	switch m {
+	case External:
+		return "external"
+	case Parasite:
+		return "parasite"
+	default:
+		return "unknown"
+	}
+}
+
+// Scratchpad reads the probe's scratchpad. If its CRC does not match, the
+// scratchpad is returned along with ErrCRC, so that it can still be inspected.
+// Like Resolution, it can be called while the probe is being read.
+func (p *Probe) Scratchpad() (Scratchpad, error) {
+	reading, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return Scratchpad{}, errors.Wrap(err, "could not read sensor device file")
+	}
+	pad, err := ParseScratchpad(string(reading))
+	if err != nil {
+		return Scratchpad{}, err
+	}
+	if !pad.Valid() {
+		return pad, ErrCRC
+	}
+	return pad, nil
+}
+
+// PowerMode returns how the probe is powered, from the ext_power attribute. It
+// returns ErrUnsupported if the driver does not have the attribute.
+func (p *Probe) PowerMode() (PowerMode, error) {
+	if !p.hasAttribute(ExtPowerFile) {
+		return 0, errors.Wrap(ErrUnsupported, "could not read power mode")
+	}
+	ext, err := p.readAttribute(ExtPowerFile)
+	if err != nil {
+		return 0, err
+	}
+	switch ext {
+	case 0:
+		return Parasite, nil
+	case 1:
+		return External, nil
+	default:
+		return 0, errors.Errorf("could not read power mode: ext_power is %d", ext)
+	}
+}
+
+// Alarms returns the probe's alarm thresholds. They are read from the alarms
+// attribute if the driver has one, and from the scratchpad otherwise.
+func (p *Probe) Alarms() (Alarms, error) {
+	if p.hasAttribute(AlarmsFile) {
+		data, err := ioutil.ReadFile(filepath.Join(p.dir, AlarmsFile))
+		if err != nil {
+			return Alarms{}, errors.Wrap(err, "could not read alarms attribute")
+		}
+		var a Alarms
+		if _, err := fmt.Sscan(string(data), &a.Low, &a.High); err != nil {
+			return Alarms{}, errors.Wrap(err, "could not parse alarms attribute")
+		}
+		return a, nil
+	}
+	pad, err := p.Scratchpad()
+	if err != nil {
+		return Alarms{}, err
+	}
+	return pad.Alarms(), nil
+}
+
+// SetAlarms writes the probe's alarm thresholds to its TL and TH registers
+// through the alarms attribute. It returns ErrUnsupported if the driver does
+// not have the attribute.
+func (p *Probe) SetAlarms(a Alarms) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	if !p.hasAttribute(AlarmsFile) {
+		return errors.Wrap(ErrUnsupported, "could not set alarms")
+	}
+	err := ioutil.WriteFile(filepath.Join(p.dir, AlarmsFile), []byte(fmt.Sprintf("%d %d\n", a.Low, a.High)), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not set alarms")
+	}
+	return nil
+}
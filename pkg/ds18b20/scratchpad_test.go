@@ -0,0 +1,97 @@
+package ds18b20_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/goodbuns/fishmon/pkg/ds18b20"
+)
+
+func TestCRC8(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+		want byte
+	}{
+		{name: "empty", want: 0},
+		// The example ROM code from Maxim application note 27.
+		{name: "ROM code", data: []byte{0x02, 0x1c, 0xb8, 0x01, 0x00, 0x00, 0x00}, want: 0xa2},
+		{name: "scratchpad", data: []byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10}, want: 0x57},
+		// Data followed by its CRC has a CRC of zero.
+		{name: "scratchpad with CRC", data: []byte{0x72, 0x01, 0x4b, 0x46, 0x7f, 0xff, 0x0e, 0x10, 0x57}, want: 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ds18b20.CRC8(test.data); got != test.want {
+				t.Errorf("got CRC %#02x, want %#02x", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseScratchpad(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		line       string
+		err        error
+		valid      bool
+		resolution int
+		want       ds18b20.Temperature
+	}{
+		{
+			name:       "valid",
+			line:       "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+			valid:      true,
+			resolution: 12,
+			want:       23.125,
+		},
+		{
+			name:       "bad CRC",
+			line:       "72 01 4b 46 7f ff 0e 10 58 : crc=58 NO",
+			resolution: 12,
+			want:       23.125,
+		},
+		{
+			// At 9 bits, the three lowest bits of the temperature are
+			// undefined.
+			name:       "9 bits",
+			line:       "7b 01 4b 46 1f ff 05 10 " + crcHex(0x7b, 0x01, 0x4b, 0x46, 0x1f, 0xff, 0x05, 0x10),
+			valid:      true,
+			resolution: 9,
+			want:       23.5,
+		},
+		{
+			name:       "below freezing",
+			line:       "cc ff 4b 46 7f ff 04 10 " + crcHex(0xcc, 0xff, 0x4b, 0x46, 0x7f, 0xff, 0x04, 0x10),
+			valid:      true,
+			resolution: 12,
+			want:       -3.25,
+		},
+		{name: "too short", line: "72 01 4b 46 7f ff 0e 10 : crc=57 YES", err: ds18b20.ErrInvalidOutput},
+		{name: "not hex", line: "72 01 4b 46 7f ff 0e 10 zz : crc=57 YES", err: ds18b20.ErrInvalidOutput},
+		{name: "empty", err: ds18b20.ErrInvalidOutput},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pad, err := ds18b20.ParseScratchpad(test.line)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := pad.Valid(); got != test.valid {
+				t.Errorf("got valid %t, want %t", got, test.valid)
+			}
+			if got := pad.Resolution(); got != test.resolution {
+				t.Errorf("got resolution %d, want %d", got, test.resolution)
+			}
+			if got := pad.Temperature(); got != test.want {
+				t.Errorf("got temperature %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// crcHex returns the CRC of data as two hex digits.
+func crcHex(data ...byte) string {
+	return fmt.Sprintf("%02x", ds18b20.CRC8(data))
+}
@@ -0,0 +1,216 @@
+package ds18b20
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Hotplug defaults.
+const (
+	DefaultScanInterval = 10 * time.Second
+	MasterSlavesFile    = "w1_master_slaves"
+)
+
+// An Op is a change to the set of probes on a bus.
+type Op int
+
+// Hotplug operations.
+const (
+	Added Op = iota + 1
+	Removed
+)
+
+func (op Op) String() string {
+	switch op {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// An Event reports that a probe was attached to or detached from a bus.
+type Event struct {
+	Op Op
+	ID ID
+}
+
+// MasterSlaves returns the probes listed by the bus masters' w1_master_slaves
+// files. The kernel updates these listings when it searches the bus, which can
+// be before the probes' device files appear or disappear.
+func (b *Bus) MasterSlaves() ([]ID, error) {
+	masters, err := filepath.Glob(filepath.Join(b.Path, MasterBusPrefix+"*", MasterSlavesFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find 1-Wire bus masters")
+	}
+	if len(masters) == 0 {
+		return nil, ErrNoBus
+	}
+
+	var slaves []ID
+	for _, master := range masters {
+		listing, err := ioutil.ReadFile(master)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read 1-Wire slave listing %s", master)
+		}
+		for _, line := range strings.Split(string(listing), "\n") {
+			// Empty buses are listed as "not found.".
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, SensorPrefix) {
+				slaves = append(slaves, ID(line))
+			}
+		}
+	}
+	return slaves, nil
+}
+
+// Present returns the probes that are attached to the bus: those that have
+// device files and, if the bus masters list their slaves, are listed.
+func (b *Bus) Present() ([]ID, error) {
+	ids, err := b.Sensors()
+	if err != nil {
+		return nil, err
+	}
+	slaves, err := b.MasterSlaves()
+	if err != nil {
+		// Not every bus lists its slaves, so fall back to device files.
+		return ids, nil
+	}
+	listed := make(map[ID]bool, len(slaves))
+	for _, id := range slaves {
+		listed[id] = true
+	}
+	var present []ID
+	for _, id := range ids {
+		if listed[id] {
+			present = append(present, id)
+		}
+	}
+	return present, nil
+}
+
+// A Watcher periodically rescans a bus and reports probes that are attached or
+// detached.
+type Watcher struct {
+	// ErrorLog logs failed scans. If nil, the standard logger is used.
+	ErrorLog *log.Logger
+
+	bus    *Bus
+	events chan Event
+	known  map[ID]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Watch scans the bus, and then rescans it every interval. Probes found by the
+// first scan are reported as Added events before Watch returns, so that the
+// caller sees every probe through Events. Events must be received promptly,
+// or scanning stalls.
+func (b *Bus) Watch(interval time.Duration) (*Watcher, error) {
+	ids, err := b.Present()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		bus:    b,
+		events: make(chan Event, len(ids)+1),
+		known:  make(map[ID]bool),
+		done:   make(chan struct{}),
+	}
+	for _, e := range w.diff(ids) {
+		w.events <- e
+	}
+
+	w.wg.Add(1)
+	go w.run(interval)
+	return w, nil
+}
+
+// Watch watches the system bus.
+func Watch(interval time.Duration) (*Watcher, error) {
+	return DefaultBus.Watch(interval)
+}
+
+// Events returns the channel that hotplug events are sent on. It is closed
+// when the watcher is closed.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Close stops watching the bus.
+func (w *Watcher) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
+
+func (w *Watcher) run(interval time.Duration) {
+	defer w.wg.Done()
+	defer close(w.events)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		ids, err := w.bus.Present()
+		if err != nil {
+			w.logf("could not scan 1-Wire bus: %s", err.Error())
+			continue
+		}
+		for _, e := range w.diff(ids) {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// diff updates the known probes, and returns events for the probes that were
+// added and removed.
+func (w *Watcher) diff(ids []ID) []Event {
+	present := make(map[ID]bool, len(ids))
+	var events []Event
+	for _, id := range ids {
+		present[id] = true
+		if !w.known[id] {
+			events = append(events, Event{Op: Added, ID: id})
+		}
+	}
+	for id := range w.known {
+		if !present[id] {
+			events = append(events, Event{Op: Removed, ID: id})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Op != events[j].Op {
+			return events[i].Op > events[j].Op
+		}
+		return events[i].ID < events[j].ID
+	})
+	w.known = present
+	return events
+}
+
+func (w *Watcher) logf(format string, args ...interface{}) {
+	if w.ErrorLog != nil {
+		w.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
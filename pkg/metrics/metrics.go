@@ -0,0 +1,299 @@
+// Package metrics implements gauges, counters and histograms that are exposed
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram buckets suitable for request latencies, in
+// seconds.
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// A Registry is a set of metrics. A Registry is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry constructs an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// A metric is a family of series that share a name and label names.
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// A series is a single labelled time series of a metric.
+type series struct {
+	labels []string
+	value  float64
+	// Histogram state.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *metric {
+	m := &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// get returns the series with the given label values, creating it if
+// necessary. It must be called with the metric's lock held.
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, but got %d values", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// delete removes the series with the given label values.
+func (m *metric) delete(values []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.series, strings.Join(values, "\xff"))
+}
+
+// deleteMatching removes every series whose value of a label is value.
+func (m *metric) deleteMatching(label, value string) {
+	i := -1
+	for j, name := range m.labels {
+		if name == label {
+			i = j
+		}
+	}
+	if i < 0 {
+		panic(fmt.Sprintf("metrics: %s has no label %s", m.name, label))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range m.series {
+		if s.labels[i] == value {
+			delete(m.series, key)
+		}
+	}
+}
+
+// A Gauge is a metric whose value can go up and down.
+type Gauge struct {
+	m *metric
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, "gauge", nil, labels)}
+}
+
+// Set sets the value of the series with the given label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(values).value = v
+}
+
+// Delete removes the series with the given label values.
+func (g *Gauge) Delete(values ...string) {
+	g.m.delete(values)
+}
+
+// DeleteMatching removes every series whose value of a label is value,
+// whatever the values of its other labels.
+func (g *Gauge) DeleteMatching(label, value string) {
+	g.m.deleteMatching(label, value)
+}
+
+// A Counter is a metric whose value only goes up.
+type Counter struct {
+	m *metric
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, "counter", nil, labels)}
+}
+
+// Inc adds one to the series with the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds a non-negative value to the series with the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.m.get(values).value += v
+}
+
+// A Histogram is a metric that counts observations in buckets.
+type Histogram struct {
+	m *metric
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds and
+// label names. If buckets is nil, DefaultBuckets are used.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{m: r.register(name, help, "histogram", buckets, labels)}
+}
+
+// Observe adds an observation to the series with the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	s := h.m.get(values)
+	for i, bound := range h.m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.(*bufio.Writer).Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *metric) write(w *countingWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.series) == 0 {
+		return
+	}
+	w.printf("# HELP %s %s\n", m.name, escapeHelp(m.help))
+	w.printf("# TYPE %s %s\n", m.name, m.typ)
+
+	// Write series in a stable order.
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != "histogram" {
+			w.printf("%s%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.value))
+			continue
+		}
+		for i, bound := range m.buckets {
+			w.printf("%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", formatValue(bound)), s.counts[i])
+		}
+		w.printf("%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", "+Inf"), s.count)
+		w.printf("%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.sum))
+		w.printf("%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels, "", ""), s.count)
+	}
+}
+
+// ServeHTTP serves the registry's metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// formatLabels formats label pairs, with an optional extra label.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+	escapeHelp  = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace
+)
+
+// countingWriter counts bytes written and records the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
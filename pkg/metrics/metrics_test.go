@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// record registers and records metrics.
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name:   "empty",
+			record: func(r *Registry) { r.NewGauge("fishmon_reading", "Latest reading.", "id") },
+		},
+		{
+			name: "gauge",
+			record: func(r *Registry) {
+				g := r.NewGauge("fishmon_reading", "Latest reading.", "id", "name")
+				g.Set(24.5, "28-2", "left tank")
+				g.Set(-1e-3, "28-1", "right tank")
+				g.Set(math.Inf(1), "28-3", "")
+			},
+			want: `# HELP fishmon_reading Latest reading.
+# TYPE fishmon_reading gauge
+fishmon_reading{id="28-1",name="right tank"} -0.001
+fishmon_reading{id="28-2",name="left tank"} 24.5
+fishmon_reading{id="28-3",name=""} +Inf
+`,
+		},
+		{
+			name: "counter without labels",
+			record: func(r *Registry) {
+				c := r.NewCounter("fishmon_uploads_total", "Uploads.")
+				c.Inc()
+				c.Add(2)
+			},
+			want: `# HELP fishmon_uploads_total Uploads.
+# TYPE fishmon_uploads_total counter
+fishmon_uploads_total 3
+`,
+		},
+		{
+			name: "histogram",
+			record: func(r *Registry) {
+				h := r.NewHistogram("fishmon_upload_seconds", "Upload latency.", []float64{1, 0.5}, "feed")
+				h.Observe(0.25, "fish.tank")
+				h.Observe(0.75, "fish.tank")
+				h.Observe(2, "fish.tank")
+			},
+			want: `# HELP fishmon_upload_seconds Upload latency.
+# TYPE fishmon_upload_seconds histogram
+fishmon_upload_seconds_bucket{feed="fish.tank",le="0.5"} 1
+fishmon_upload_seconds_bucket{feed="fish.tank",le="1"} 2
+fishmon_upload_seconds_bucket{feed="fish.tank",le="+Inf"} 3
+fishmon_upload_seconds_sum{feed="fish.tank"} 3
+fishmon_upload_seconds_count{feed="fish.tank"} 3
+`,
+		},
+		{
+			name: "escaping",
+			record: func(r *Registry) {
+				g := r.NewGauge("fishmon_reading", "Latest reading\nof a \\ \"sensor\".", "name")
+				g.Set(1, "the \"big\"\n\\ tank")
+			},
+			want: `# HELP fishmon_reading Latest reading\nof a \\ "sensor".
+# TYPE fishmon_reading gauge
+fishmon_reading{name="the \"big\"\n\\ tank"} 1
+`,
+		},
+		{
+			name: "deleted",
+			record: func(r *Registry) {
+				g := r.NewGauge("fishmon_reading", "Latest reading.", "id", "name")
+				g.Set(1, "28-1", "left tank")
+				g.Set(2, "28-1", "old name")
+				g.Set(3, "28-2", "right tank")
+				g.Set(4, "28-3", "shrimp tank")
+				g.DeleteMatching("id", "28-1")
+				g.Delete("28-3", "shrimp tank")
+			},
+			want: `# HELP fishmon_reading Latest reading.
+# TYPE fishmon_reading gauge
+fishmon_reading{id="28-2",name="right tank"} 3
+`,
+		},
+		{
+			name: "several metrics in order",
+			record: func(r *Registry) {
+				r.NewCounter("b_total", "B.").Inc()
+				r.NewGauge("a", "A.").Set(1)
+			},
+			want: `# HELP b_total B.
+# TYPE b_total counter
+b_total 1
+# HELP a A.
+# TYPE a gauge
+a 1
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry()
+			test.record(r)
+			var buf bytes.Buffer
+			n, err := r.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("could not write metrics: %s", err)
+			}
+			if got := buf.String(); got != test.want {
+				t.Errorf("got metrics\n%s\nwant\n%s", got, test.want)
+			}
+			if n != int64(buf.Len()) {
+				t.Errorf("got %d bytes written, want %d", n, buf.Len())
+			}
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("fishmon_reading", "Latest reading.").Set(1)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("got content type %q, want %q", got, ContentType)
+	}
+	if got, want := w.Body.String(), "# HELP fishmon_reading Latest reading.\n# TYPE fishmon_reading gauge\nfishmon_reading 1\n"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,416 @@
+// Package mqtt implements a minimal MQTT 3.1.1 client for publishing messages.
+//
+// The client keeps its connection alive, reconnects with backoff when the
+// connection is lost, and redelivers unacknowledged QoS 1 messages after
+// reconnecting.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Client defaults.
+const (
+	DefaultKeepAlive   = time.Minute
+	DefaultDialTimeout = 10 * time.Second
+	MinBackoff         = time.Second
+	MaxBackoff         = time.Minute
+)
+
+// Client errors.
+var (
+	ErrClosed       = errors.New("MQTT client is closed")
+	ErrNotConnected = errors.New("MQTT client is not connected")
+	ErrQoS          = errors.New("unsupported MQTT QoS level")
+)
+
+// A RefusedError is returned when the server refuses a connection.
+type RefusedError struct {
+	ReturnCode byte
+}
+
+func (e *RefusedError) Error() string {
+	reasons := map[byte]string{
+		RefusedVersion:        "unacceptable protocol version",
+		RefusedIdentifier:     "identifier rejected",
+		RefusedUnavailable:    "server unavailable",
+		RefusedBadCredentials: "bad user name or password",
+		RefusedNotAuthorized:  "not authorized",
+	}
+	reason, ok := reasons[e.ReturnCode]
+	if !ok {
+		reason = fmt.Sprintf("return code %d", e.ReturnCode)
+	}
+	return "MQTT connection refused: " + reason
+}
+
+// Options configure a Client.
+type Options struct {
+	// Addr is the host:port address of the server.
+	Addr string
+	// TLSConfig enables TLS if it is not nil.
+	TLSConfig *tls.Config
+
+	ClientID string
+	Username string
+	Password string
+
+	// KeepAlive is the longest time allowed between control packets. If zero,
+	// DefaultKeepAlive is used.
+	KeepAlive time.Duration
+	// DialTimeout limits how long connecting and writing packets may take. If
+	// zero, DefaultDialTimeout is used.
+	DialTimeout time.Duration
+	// CleanSession asks the server to discard any previous session state.
+	CleanSession bool
+}
+
+// A Client publishes messages to an MQTT server. A Client is safe for
+// concurrent use.
+type Client struct {
+	opts Options
+
+	mu       sync.Mutex
+	conn     net.Conn
+	closed   bool
+	done     chan struct{}
+	nextID   uint16
+	inflight map[uint16]*message
+	order    []uint16
+
+	// sessionPresent reports whether the server resumed a previous session on
+	// the most recent connection.
+	sessionPresent bool
+}
+
+// A message is an unacknowledged QoS 1 message.
+type message struct {
+	publish *Publish
+	acked   chan struct{}
+}
+
+// Dial connects to an MQTT server. If the connection is later lost, the client
+// reconnects in the background until it is closed.
+func Dial(opts Options) (*Client, error) {
+	c := newClient(opts)
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialBackground starts a client that connects to an MQTT server in the
+// background, retrying with backoff until it succeeds or the client is closed,
+// so that a server that cannot be reached yet does not stop the caller from
+// starting. QoS 1 messages published before the client connects are delivered
+// once it does.
+func DialBackground(opts Options) *Client {
+	c := newClient(opts)
+	go func() {
+		if err := c.connect(); err != nil && err != ErrClosed {
+			c.reconnect()
+		}
+	}()
+	return c
+}
+
+func newClient(opts Options) *Client {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = DefaultDialTimeout
+	}
+	return &Client{
+		opts:     opts,
+		done:     make(chan struct{}),
+		inflight: make(map[uint16]*message),
+	}
+}
+
+// Connected reports whether the client is currently connected.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// SessionPresent reports whether the server resumed a previous session on the
+// most recent connection.
+func (c *Client) SessionPresent() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionPresent
+}
+
+// connect establishes a connection and redelivers unacknowledged messages.
+func (c *Client) connect() error {
+	// Dial server.
+	dialer := &net.Dialer{Timeout: c.opts.DialTimeout}
+	var conn net.Conn
+	var err error
+	if c.opts.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.opts.Addr, c.opts.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.opts.Addr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not connect to MQTT server %s", c.opts.Addr)
+	}
+
+	// Send CONNECT and wait for CONNACK.
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(c.opts.DialTimeout))
+	err = WritePacket(conn, &Connect{
+		ClientID:     c.opts.ClientID,
+		Username:     c.opts.Username,
+		Password:     c.opts.Password,
+		KeepAlive:    uint16(c.opts.KeepAlive / time.Second),
+		CleanSession: c.opts.CleanSession,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p, err := ReadPacket(r)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "could not read MQTT CONNACK")
+	}
+	ack, ok := p.(*ConnAck)
+	if !ok {
+		conn.Close()
+		return errors.Wrap(ErrMalformed, "expected MQTT CONNACK")
+	}
+	if ack.ReturnCode != Accepted {
+		conn.Close()
+		return &RefusedError{ReturnCode: ack.ReturnCode}
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		conn.Close()
+		return ErrClosed
+	}
+	c.conn = conn
+	c.sessionPresent = ack.SessionPresent
+
+	// Redeliver unacknowledged messages, in order.
+	for _, id := range c.order {
+		m := c.inflight[id]
+		m.publish.Dup = true
+		if err := c.write(m.publish); err != nil {
+			break
+		}
+	}
+
+	stop := make(chan struct{})
+	go c.read(conn, r, stop)
+	go c.ping(conn, stop)
+	return nil
+}
+
+// read handles packets from the server until the connection fails.
+func (c *Client) read(conn net.Conn, r *bufio.Reader, stop chan struct{}) {
+	defer close(stop)
+	for {
+		// Expect at least a PINGRESP within each keep alive period.
+		conn.SetReadDeadline(time.Now().Add(c.opts.KeepAlive * 3 / 2))
+		p, err := ReadPacket(r)
+		if err != nil {
+			c.lost(conn)
+			return
+		}
+		switch p := p.(type) {
+		case *PubAck:
+			c.mu.Lock()
+			if m, ok := c.inflight[p.PacketID]; ok {
+				c.remove(p.PacketID)
+				close(m.acked)
+			}
+			c.mu.Unlock()
+		case *Publish:
+			// The client does not subscribe, but acknowledges any messages
+			// the server sends anyway.
+			if p.QoS == 1 {
+				c.mu.Lock()
+				if c.conn == conn {
+					c.write(&PubAck{PacketID: p.PacketID})
+				}
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+// ping sends keep alive pings until the connection fails.
+func (c *Client) ping(conn net.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(c.opts.KeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.conn == conn {
+				c.write(&PingReq{})
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// lost handles a failed connection by reconnecting in the background.
+func (c *Client) lost(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn.Close()
+	if c.conn != conn {
+		return
+	}
+	c.conn = nil
+	if !c.closed {
+		go c.reconnect()
+	}
+}
+
+// reconnect reconnects with jittered exponential backoff until it succeeds or
+// the client is closed.
+func (c *Client) reconnect() {
+	backoff := MinBackoff
+	for {
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-c.done:
+			return
+		case <-time.After(wait):
+		}
+		err := c.connect()
+		if err == nil || err == ErrClosed {
+			return
+		}
+		backoff *= 2
+		if backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+}
+
+// write sends a packet on the current connection, closing the connection if
+// the write fails. It must be called with the lock held.
+func (c *Client) write(p Packet) error {
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.DialTimeout))
+	if err := WritePacket(c.conn, p); err != nil {
+		// Closing the connection makes the reader reconnect.
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// remove forgets an inflight message. It must be called with the lock held.
+func (c *Client) remove(id uint16) {
+	delete(c.inflight, id)
+	for i, other := range c.order {
+		if other == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Publish sends a message to a topic. QoS 0 messages are sent at most once,
+// and fail if the client is not connected. QoS 1 messages are sent at least
+// once: Publish waits until the server acknowledges the message, redelivering
+// it after reconnecting if necessary, or until ctx is done.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	p := &Publish{
+		Topic:   topic,
+		Payload: payload,
+		QoS:     qos,
+		Retain:  retain,
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	switch qos {
+	case 0:
+		err := c.write(p)
+		c.mu.Unlock()
+		if err != nil {
+			return errors.Wrap(err, "could not publish MQTT message")
+		}
+		return nil
+	case 1:
+	default:
+		c.mu.Unlock()
+		return ErrQoS
+	}
+
+	// Allocate a packet identifier. Zero is not a valid identifier.
+	for {
+		c.nextID++
+		if _, ok := c.inflight[c.nextID]; !ok && c.nextID != 0 {
+			break
+		}
+	}
+	p.PacketID = c.nextID
+	m := &message{publish: p, acked: make(chan struct{})}
+	c.inflight[p.PacketID] = m
+	c.order = append(c.order, p.PacketID)
+
+	// If the write fails, the message is redelivered after reconnecting.
+	c.write(p)
+	c.mu.Unlock()
+
+	select {
+	case <-m.acked:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.remove(p.PacketID)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Close disconnects from the server. Unacknowledged messages are abandoned.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	if c.conn == nil {
+		return nil
+	}
+	c.write(&Disconnect{})
+	err := c.conn.Close()
+	c.conn = nil
+	if err != nil {
+		return errors.Wrap(err, "could not close MQTT connection")
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package mqtt_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/mqtt"
+	"github.com/goodbuns/fishmon/pkg/mqtt/mqtttest"
+)
+
+// waitFor waits until cond holds. Reconnecting takes up to mqtt.MinBackoff.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * mqtt.MinBackoff)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newBroker(t *testing.T) *mqtttest.Broker {
+	t.Helper()
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatalf("could not start broker: %s", err)
+	}
+	return b
+}
+
+func TestDial(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		username string
+		password string
+		code     byte
+	}{
+		{name: "accepted", username: "fish", password: "secret"},
+		{name: "wrong password", username: "fish", password: "guess", code: mqtt.RefusedBadCredentials},
+		{name: "no credentials", code: mqtt.RefusedBadCredentials},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBroker(t)
+			defer b.Close()
+			b.SetCredentials("fish", "secret")
+
+			c, err := mqtt.Dial(mqtt.Options{
+				Addr:     b.Addr,
+				ClientID: "fishmon",
+				Username: test.username,
+				Password: test.password,
+			})
+			if test.code == mqtt.Accepted {
+				if err != nil {
+					t.Fatalf("could not connect: %s", err)
+				}
+				defer c.Close()
+				if !c.Connected() {
+					t.Errorf("client is not connected")
+				}
+				return
+			}
+			refused, ok := errors.Cause(err).(*mqtt.RefusedError)
+			if !ok {
+				t.Fatalf("got error %v, want connection refused", err)
+			}
+			if refused.ReturnCode != test.code {
+				t.Errorf("got return code %d, want %d", refused.ReturnCode, test.code)
+			}
+		})
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	b := newBroker(t)
+	addr := b.Addr
+	b.Close()
+	if _, err := mqtt.Dial(mqtt.Options{Addr: addr, ClientID: "fishmon"}); err == nil {
+		t.Errorf("connected to a closed broker")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		qos  byte
+		ack  bool
+		err  error
+	}{
+		{name: "qos 0", qos: 0, ack: true},
+		{name: "qos 0 without acknowledgement", qos: 0},
+		{name: "qos 1", qos: 1, ack: true},
+		{name: "qos 1 without acknowledgement", qos: 1, err: context.DeadlineExceeded},
+		{name: "qos 2", qos: 2, ack: true, err: mqtt.ErrQoS},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBroker(t)
+			defer b.Close()
+			b.SetAck(test.ack)
+			c, err := mqtt.Dial(mqtt.Options{Addr: b.Addr, ClientID: "fishmon"})
+			if err != nil {
+				t.Fatalf("could not connect: %s", err)
+			}
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err = c.Publish(ctx, "fish/feeds/tank", []byte("75.000"), test.qos, false)
+			if errors.Cause(err) != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if test.err == mqtt.ErrQoS {
+				return
+			}
+			waitFor(t, "the message", func() bool { return len(b.Messages()) == 1 })
+			m := b.Messages()[0]
+			if m.Topic != "fish/feeds/tank" || string(m.Payload) != "75.000" || m.QoS != test.qos || m.Dup {
+				t.Errorf("got message %+v", m)
+			}
+		})
+	}
+}
+
+func TestRedeliverAfterReconnect(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		cleanSession bool
+	}{
+		{name: "resumed session"},
+		{name: "clean session", cleanSession: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newBroker(t)
+			defer b.Close()
+			b.SetAck(false)
+			c, err := mqtt.Dial(mqtt.Options{Addr: b.Addr, ClientID: "fishmon", CleanSession: test.cleanSession})
+			if err != nil {
+				t.Fatalf("could not connect: %s", err)
+			}
+			defer c.Close()
+
+			// Publish a message that the broker receives but does not
+			// acknowledge, then drop the connection.
+			published := make(chan error, 1)
+			go func() {
+				published <- c.Publish(context.Background(), "fish/feeds/tank", []byte("75.000"), 1, false)
+			}()
+			waitFor(t, "the message", func() bool { return len(b.Messages()) == 1 })
+			b.SetAck(true)
+			b.DropConnections()
+
+			// The client reconnects and redelivers the message.
+			select {
+			case err := <-published:
+				if err != nil {
+					t.Fatalf("could not publish: %s", err)
+				}
+			case <-time.After(5 * mqtt.MinBackoff):
+				t.Fatal("timed out waiting for the message to be redelivered")
+			}
+			messages := b.Messages()
+			if len(messages) != 2 {
+				t.Fatalf("got %d messages, want the message and its redelivery", len(messages))
+			}
+			if m := messages[1]; string(m.Payload) != "75.000" || !m.Dup {
+				t.Errorf("got redelivered message %+v, want it marked as a duplicate", m)
+			}
+			if got := len(b.Connects()); got != 2 {
+				t.Errorf("got %d connections, want 2", got)
+			}
+			if got, want := c.SessionPresent(), !test.cleanSession; got != want {
+				t.Errorf("got session present %t, want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+	c, err := mqtt.Dial(mqtt.Options{Addr: b.Addr, ClientID: "fishmon"})
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+	defer c.Close()
+
+	b.DropConnections()
+	waitFor(t, "the connection to be lost", func() bool { return !c.Connected() })
+	if err := c.Publish(context.Background(), "fish/feeds/tank", []byte("75.000"), 0, false); errors.Cause(err) != mqtt.ErrNotConnected {
+		t.Errorf("got error %v publishing while disconnected, want %v", err, mqtt.ErrNotConnected)
+	}
+	waitFor(t, "the client to reconnect", c.Connected)
+	if err := c.Publish(context.Background(), "fish/feeds/tank", []byte("75.000"), 0, false); err != nil {
+		t.Errorf("could not publish after reconnecting: %s", err)
+	}
+}
+
+func TestDialBackground(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+	b.SetCredentials("fish", "secret")
+
+	// Messages published before the client connects are delivered once it
+	// does.
+	c := mqtt.DialBackground(mqtt.Options{Addr: b.Addr, ClientID: "fishmon", Username: "fish", Password: "secret"})
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*mqtt.MinBackoff)
+	defer cancel()
+	if err := c.Publish(ctx, "fish/feeds/tank", []byte("75.000"), 1, false); err != nil {
+		t.Fatalf("could not publish: %s", err)
+	}
+	if !c.Connected() {
+		t.Errorf("client is not connected")
+	}
+	if got := len(b.Messages()); got != 1 {
+		t.Errorf("got %d messages, want 1", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := newBroker(t)
+	defer b.Close()
+	b.SetAck(false)
+	c, err := mqtt.Dial(mqtt.Options{Addr: b.Addr, ClientID: "fishmon"})
+	if err != nil {
+		t.Fatalf("could not connect: %s", err)
+	}
+
+	// Closing abandons messages waiting to be acknowledged.
+	published := make(chan error, 1)
+	go func() {
+		published <- c.Publish(context.Background(), "fish/feeds/tank", []byte("75.000"), 1, false)
+	}()
+	waitFor(t, "the message", func() bool { return len(b.Messages()) == 1 })
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close: %s", err)
+	}
+	if err := <-published; err != mqtt.ErrClosed {
+		t.Errorf("got error %v, want %v", err, mqtt.ErrClosed)
+	}
+	if err := c.Publish(context.Background(), "fish/feeds/tank", []byte("75.000"), 0, false); err != mqtt.ErrClosed {
+		t.Errorf("got error %v publishing after closing, want %v", err, mqtt.ErrClosed)
+	}
+}
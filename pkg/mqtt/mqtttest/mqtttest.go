@@ -0,0 +1,199 @@
+// Package mqtttest provides an in-process MQTT broker for testing publishers.
+//
+// The broker accepts MQTT 3.1.1 connections on a local TCP port, records
+// published messages, and keeps session state for clients that do not request
+// a clean session. Tests can drop connections and withhold acknowledgements to
+// exercise reconnection and redelivery.
+package mqtttest
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/goodbuns/fishmon/pkg/mqtt"
+)
+
+// A Broker is an in-process MQTT broker.
+type Broker struct {
+	// Addr is the host:port address that the broker listens on.
+	Addr string
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	closed   bool
+	username string
+	password string
+	ack      bool
+	conns    map[net.Conn]bool
+	sessions map[string]bool
+	messages []Message
+	connects []mqtt.Connect
+	wg       sync.WaitGroup
+}
+
+// A Message is a message published to the broker.
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+}
+
+// NewBroker starts a broker listening on a local port. The caller should call
+// Close when finished, to shut it down.
+func NewBroker() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		ack:      true,
+		conns:    make(map[net.Conn]bool),
+		sessions: make(map[string]bool),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// SetCredentials requires clients to connect with a username and password.
+func (b *Broker) SetCredentials(username, password string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.username = username
+	b.password = password
+}
+
+// SetAck sets whether the broker acknowledges QoS 1 messages. Messages that
+// are not acknowledged are still recorded.
+func (b *Broker) SetAck(ack bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ack = ack
+}
+
+// Messages returns the messages published to the broker, in order.
+func (b *Broker) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// Connects returns the CONNECT packets that the broker has accepted, in order.
+func (b *Broker) Connects() []mqtt.Connect {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]mqtt.Connect(nil), b.connects...)
+}
+
+// DropConnections closes all client connections, as if the network failed.
+func (b *Broker) DropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// Close shuts down the broker and closes all client connections.
+func (b *Broker) Close() error {
+	err := b.listener.Close()
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.DropConnections()
+	b.wg.Wait()
+	return err
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = true
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+
+	// The first packet must be a CONNECT.
+	p, err := mqtt.ReadPacket(r)
+	if err != nil {
+		return
+	}
+	connect, ok := p.(*mqtt.Connect)
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	if b.username != "" && (connect.Username != b.username || connect.Password != b.password) {
+		b.mu.Unlock()
+		mqtt.WritePacket(conn, &mqtt.ConnAck{ReturnCode: mqtt.RefusedBadCredentials})
+		return
+	}
+	present := !connect.CleanSession && b.sessions[connect.ClientID]
+	b.sessions[connect.ClientID] = !connect.CleanSession
+	b.connects = append(b.connects, *connect)
+	b.mu.Unlock()
+	if err := mqtt.WritePacket(conn, &mqtt.ConnAck{SessionPresent: present}); err != nil {
+		return
+	}
+
+	for {
+		p, err := mqtt.ReadPacket(r)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *mqtt.Publish:
+			b.mu.Lock()
+			b.messages = append(b.messages, Message{
+				ClientID: connect.ClientID,
+				Topic:    p.Topic,
+				Payload:  p.Payload,
+				QoS:      p.QoS,
+				Retain:   p.Retain,
+				Dup:      p.Dup,
+			})
+			ack := b.ack
+			b.mu.Unlock()
+			if p.QoS == 1 && ack {
+				if err := mqtt.WritePacket(conn, &mqtt.PubAck{PacketID: p.PacketID}); err != nil {
+					return
+				}
+			}
+		case *mqtt.PingReq:
+			if err := mqtt.WritePacket(conn, &mqtt.PingResp{}); err != nil {
+				return
+			}
+		case *mqtt.Disconnect:
+			return
+		}
+	}
+}
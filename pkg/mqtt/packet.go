@@ -0,0 +1,305 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Control packet types.
+const (
+	TypeConnect    = 1
+	TypeConnAck    = 2
+	TypePublish    = 3
+	TypePubAck     = 4
+	TypePingReq    = 12
+	TypePingResp   = 13
+	TypeDisconnect = 14
+)
+
+// CONNACK return codes.
+const (
+	Accepted              = 0
+	RefusedVersion        = 1
+	RefusedIdentifier     = 2
+	RefusedUnavailable    = 3
+	RefusedBadCredentials = 4
+	RefusedNotAuthorized  = 5
+)
+
+// Protocol constants.
+const (
+	protocolLevel          = 4
+	maxRemainingLength     = 268435455
+	remainingLengthMaxSize = 4
+)
+
+// Packet errors.
+var (
+	ErrMalformed = errors.New("malformed MQTT packet")
+	ErrTooLarge  = errors.New("MQTT packet is too large")
+)
+
+// A Packet is an MQTT control packet.
+type Packet interface {
+	// encode returns the packet's fixed header flags and the rest of the
+	// packet after the fixed header.
+	encode() (byte, []byte)
+}
+
+// Connect is a CONNECT packet.
+type Connect struct {
+	ClientID     string
+	Username     string
+	Password     string
+	KeepAlive    uint16
+	CleanSession bool
+}
+
+// ConnAck is a CONNACK packet.
+type ConnAck struct {
+	SessionPresent bool
+	ReturnCode     byte
+}
+
+// Publish is a PUBLISH packet.
+type Publish struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retain   bool
+	Dup      bool
+	PacketID uint16
+}
+
+// PubAck is a PUBACK packet.
+type PubAck struct {
+	PacketID uint16
+}
+
+// PingReq is a PINGREQ packet.
+type PingReq struct{}
+
+// PingResp is a PINGRESP packet.
+type PingResp struct{}
+
+// Disconnect is a DISCONNECT packet.
+type Disconnect struct{}
+
+func (p *Connect) encode() (byte, []byte) {
+	var flags byte
+	if p.Username != "" {
+		flags |= 0x80
+	}
+	if p.Password != "" {
+		flags |= 0x40
+	}
+	if p.CleanSession {
+		flags |= 0x02
+	}
+	b := appendString(nil, "MQTT")
+	b = append(b, protocolLevel, flags)
+	b = appendUint16(b, p.KeepAlive)
+	b = appendString(b, p.ClientID)
+	if p.Username != "" {
+		b = appendString(b, p.Username)
+	}
+	if p.Password != "" {
+		b = appendString(b, p.Password)
+	}
+	return TypeConnect << 4, b
+}
+
+func (p *ConnAck) encode() (byte, []byte) {
+	var flags byte
+	if p.SessionPresent {
+		flags = 0x01
+	}
+	return TypeConnAck << 4, []byte{flags, p.ReturnCode}
+}
+
+func (p *Publish) encode() (byte, []byte) {
+	header := byte(TypePublish<<4) | (p.QoS&0x03)<<1
+	if p.Dup {
+		header |= 0x08
+	}
+	if p.Retain {
+		header |= 0x01
+	}
+	b := appendString(nil, p.Topic)
+	if p.QoS > 0 {
+		b = appendUint16(b, p.PacketID)
+	}
+	return header, append(b, p.Payload...)
+}
+
+func (p *PubAck) encode() (byte, []byte) {
+	return TypePubAck << 4, appendUint16(nil, p.PacketID)
+}
+
+func (p *PingReq) encode() (byte, []byte) {
+	return TypePingReq << 4, nil
+}
+
+func (p *PingResp) encode() (byte, []byte) {
+	return TypePingResp << 4, nil
+}
+
+func (p *Disconnect) encode() (byte, []byte) {
+	return TypeDisconnect << 4, nil
+}
+
+// WritePacket writes a control packet.
+func WritePacket(w io.Writer, p Packet) error {
+	header, body := p.encode()
+	if len(body) > maxRemainingLength {
+		return ErrTooLarge
+	}
+	b := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			break
+		}
+	}
+	if _, err := w.Write(append(b, body...)); err != nil {
+		return errors.Wrap(err, "could not write MQTT packet")
+	}
+	return nil
+}
+
+// ReadPacket reads a control packet.
+func ReadPacket(r *bufio.Reader) (Packet, error) {
+	// Read fixed header.
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var length int
+	for i, multiplier := 0, 1; ; i, multiplier = i+1, multiplier*128 {
+		if i == remainingLengthMaxSize {
+			return nil, ErrMalformed
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// Decode variable header and payload.
+	d := decoder{b: body}
+	switch header >> 4 {
+	case TypeConnect:
+		p := &Connect{}
+		if d.string() != "MQTT" || d.byte() != protocolLevel {
+			return nil, ErrMalformed
+		}
+		flags := d.byte()
+		p.CleanSession = flags&0x02 != 0
+		p.KeepAlive = d.uint16()
+		p.ClientID = d.string()
+		if flags&0x80 != 0 {
+			p.Username = d.string()
+		}
+		if flags&0x40 != 0 {
+			p.Password = d.string()
+		}
+		return p, d.err
+	case TypeConnAck:
+		p := &ConnAck{}
+		p.SessionPresent = d.byte()&0x01 != 0
+		p.ReturnCode = d.byte()
+		return p, d.err
+	case TypePublish:
+		p := &Publish{
+			QoS:    (header >> 1) & 0x03,
+			Dup:    header&0x08 != 0,
+			Retain: header&0x01 != 0,
+		}
+		p.Topic = d.string()
+		if p.QoS > 0 {
+			p.PacketID = d.uint16()
+		}
+		p.Payload = d.rest()
+		return p, d.err
+	case TypePubAck:
+		p := &PubAck{}
+		p.PacketID = d.uint16()
+		return p, d.err
+	case TypePingReq:
+		return &PingReq{}, nil
+	case TypePingResp:
+		return &PingResp{}, nil
+	case TypeDisconnect:
+		return &Disconnect{}, nil
+	default:
+		return nil, errors.Wrapf(ErrMalformed, "unsupported packet type %d", header>>4)
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint16(b, uint16(len(s))), s...)
+}
+
+// A decoder reads fields from a packet, recording the first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.err = ErrMalformed
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if len(d.b) < 2 {
+		d.err = ErrMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := int(d.uint16())
+	if len(d.b) < n {
+		d.err = ErrMalformed
+		return ""
+	}
+	v := string(d.b[:n])
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) rest() []byte {
+	v := d.b
+	d.b = nil
+	return v
+}
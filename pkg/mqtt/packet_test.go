@@ -0,0 +1,96 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		packet Packet
+	}{
+		{name: "connect", packet: &Connect{ClientID: "fishmon", KeepAlive: 60}},
+		{
+			name: "connect with credentials",
+			packet: &Connect{
+				ClientID:     "fishmon-fish",
+				Username:     "fish",
+				Password:     "secret",
+				KeepAlive:    30,
+				CleanSession: true,
+			},
+		},
+		{name: "connack", packet: &ConnAck{}},
+		{name: "connack refused", packet: &ConnAck{ReturnCode: RefusedBadCredentials}},
+		{name: "connack session present", packet: &ConnAck{SessionPresent: true}},
+		{name: "publish qos 0", packet: &Publish{Topic: "fish/feeds/tank", Payload: []byte("75.000")}},
+		{
+			name:   "publish qos 1",
+			packet: &Publish{Topic: "fish/feeds/tank", Payload: []byte("75.000"), QoS: 1, PacketID: 513},
+		},
+		{
+			name:   "publish redelivered and retained",
+			packet: &Publish{Topic: "fish/feeds/tank", Payload: []byte("75.000"), QoS: 1, Dup: true, Retain: true, PacketID: 1},
+		},
+		{name: "publish empty payload", packet: &Publish{Topic: "fish/feeds/tank", Payload: []byte{}}},
+		// Payloads of 128 bytes or more need a multi-byte remaining length.
+		{
+			name:   "publish long payload",
+			packet: &Publish{Topic: "fish/feeds/tank", Payload: []byte(strings.Repeat("x", 20000))},
+		},
+		{name: "puback", packet: &PubAck{PacketID: 65535}},
+		{name: "pingreq", packet: &PingReq{}},
+		{name: "pingresp", packet: &PingResp{}},
+		{name: "disconnect", packet: &Disconnect{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WritePacket(&buf, test.packet); err != nil {
+				t.Fatalf("could not write packet: %s", err)
+			}
+			r := bufio.NewReader(&buf)
+			got, err := ReadPacket(r)
+			if err != nil {
+				t.Fatalf("could not read packet: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.packet) {
+				t.Errorf("got packet %+v, want %+v", got, test.packet)
+			}
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Errorf("packet was not read to its end")
+			}
+		})
+	}
+}
+
+func TestReadPacketMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "empty", err: io.EOF},
+		{name: "missing length", data: []byte{TypePingReq << 4}, err: io.EOF},
+		{name: "length too long", data: []byte{TypePingReq << 4, 0x80, 0x80, 0x80, 0x80, 0x01}, err: ErrMalformed},
+		{name: "truncated body", data: []byte{TypePubAck << 4, 2, 0}, err: io.ErrUnexpectedEOF},
+		{name: "short puback", data: []byte{TypePubAck << 4, 1, 0}, err: ErrMalformed},
+		{name: "short connack", data: []byte{TypeConnAck << 4, 1, 0}, err: ErrMalformed},
+		{name: "topic past end", data: []byte{TypePublish << 4, 3, 0, 5, 'f'}, err: ErrMalformed},
+		{name: "wrong protocol", data: []byte{TypeConnect << 4, 7, 0, 4, 'M', 'Q', 'T', 'X', protocolLevel}, err: ErrMalformed},
+		{name: "unsupported type", data: []byte{8 << 4, 0}, err: ErrMalformed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadPacket(bufio.NewReader(bytes.NewReader(test.data)))
+			if errors.Cause(err) != test.err {
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+		})
+	}
+}
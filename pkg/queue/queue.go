@@ -0,0 +1,472 @@
+// Package queue implements a durable on-disk FIFO queue, stored as an
+// append-only log of segment files.
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Queue file names.
+const (
+	SegmentSuffix = ".log"
+	CursorFile    = "cursor"
+)
+
+// Size limits.
+const (
+	DefaultSegmentSize = 1 << 20
+	MaxRecordSize      = 1 << 20
+)
+
+// Queue errors.
+var (
+	ErrEmpty  = errors.New("queue is empty")
+	ErrClosed = errors.New("queue is closed")
+	ErrTooBig = errors.New("queue record is too large")
+	ErrNoData = errors.New("queue record is empty")
+)
+
+// Each record is stored as a header followed by its payload. The header
+// contains the payload length and its CRC-32 checksum. Payloads are never
+// empty, so that zeroed space, which a crash or power loss can leave at the
+// end of a segment, is not mistaken for records.
+const headerSize = 8
+
+// A Queue is a durable FIFO queue of records. Records are appended to the
+// newest segment file, and consumed from the oldest. A cursor file records the
+// position of the oldest unconsumed record, and segments are deleted once all
+// of their records have been consumed.
+//
+// A Queue is safe for concurrent use.
+type Queue struct {
+	dir         string
+	segmentSize int64
+
+	mu       sync.Mutex
+	closed   bool
+	segments []uint64
+
+	// Writer state. torn is whether a failed write may have left part of a
+	// record after wSize, which must be removed before the next write.
+	w     *os.File
+	wSize int64
+	torn  bool
+
+	// Reader state.
+	r    *os.File
+	rSeg uint64
+	rOff int64
+
+	ready chan struct{}
+}
+
+type cursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Open opens the queue stored in dir, creating it if it does not exist.
+// Segments are rotated once they grow larger than segmentSize bytes.
+func Open(dir string, segmentSize int64) (*Queue, error) {
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create queue directory %s", dir)
+	}
+	q := &Queue{
+		dir:         dir,
+		segmentSize: segmentSize,
+		ready:       make(chan struct{}, 1),
+	}
+
+	// Find existing segments.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read queue directory %s", dir)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), SegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), SegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, seq)
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i] < q.segments[j]
+	})
+	if len(q.segments) == 0 {
+		q.segments = []uint64{1}
+	}
+
+	// Load the read cursor.
+	c, err := q.readCursor()
+	if err != nil {
+		return nil, err
+	}
+	for len(q.segments) > 1 && q.segments[0] < c.Segment {
+		// Remove segments that were consumed before they could be deleted.
+		if err := os.Remove(q.segmentPath(q.segments[0])); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "could not remove consumed queue segment")
+		}
+		q.segments = q.segments[1:]
+	}
+	if c.Segment != q.segments[0] {
+		c = cursor{Segment: q.segments[0]}
+	}
+	q.rSeg, q.rOff = c.Segment, c.Offset
+
+	// Open the newest segment for writing, discarding any partially written
+	// record at its end.
+	last := q.segments[len(q.segments)-1]
+	w, err := os.OpenFile(q.segmentPath(last), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open queue segment")
+	}
+	size, err := validSize(w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Truncate(size); err != nil {
+		w.Close()
+		return nil, errors.Wrap(err, "could not truncate queue segment")
+	}
+	if _, err := w.Seek(size, io.SeekStart); err != nil {
+		w.Close()
+		return nil, errors.Wrap(err, "could not seek queue segment")
+	}
+	q.w, q.wSize = w, size
+	if q.rSeg == last && q.rOff > size {
+		q.rOff = size
+	}
+
+	return q, nil
+}
+
+// Append durably adds a record to the end of the queue. Records must not be
+// empty.
+func (q *Queue) Append(data []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	if len(data) == 0 {
+		return ErrNoData
+	}
+	if len(data) > MaxRecordSize {
+		return ErrTooBig
+	}
+
+	// Remove what a failed write left behind, so that records are not written
+	// after it, where they could not be read.
+	if q.torn {
+		if err := q.truncate(); err != nil {
+			return err
+		}
+	}
+
+	// Rotate full segments.
+	if q.wSize >= q.segmentSize {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+	if _, err := q.w.Write(record); err != nil {
+		q.torn = true
+		q.truncate()
+		return errors.Wrap(err, "could not write queue record")
+	}
+	if err := q.w.Sync(); err != nil {
+		q.torn = true
+		q.truncate()
+		return errors.Wrap(err, "could not sync queue segment")
+	}
+	q.wSize += int64(len(record))
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// AppendJSON marshals v and appends it to the queue.
+func (q *Queue) AppendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal queue record")
+	}
+	return q.Append(data)
+}
+
+// Peek returns the oldest record in the queue without removing it. If the
+// queue is empty, Peek returns ErrEmpty.
+func (q *Queue) Peek() ([]byte, error) {
+	records, err := q.PeekN(1)
+	if err != nil {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// PeekN returns up to n of the oldest records in the queue without removing
+// them. It may return fewer than n records even if more are queued, but always
+// returns at least one. If the queue is empty, PeekN returns ErrEmpty.
+func (q *Queue) PeekN(n int) ([][]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil, ErrClosed
+	}
+	data, next, err := q.head()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read ahead within the head segment.
+	records := [][]byte{data}
+	for len(records) < n {
+		data, next, err = readRecord(q.r, next)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, data)
+	}
+	return records, nil
+}
+
+// Pop removes the oldest record from the queue.
+func (q *Queue) Pop() error {
+	return q.PopN(1)
+}
+
+// PopN removes the n oldest records from the queue. If the queue has fewer
+// than n records, PopN removes all of them and returns ErrEmpty.
+func (q *Queue) PopN(n int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	var popErr error
+	for i := 0; i < n; i++ {
+		_, next, err := q.head()
+		if err != nil {
+			popErr = err
+			break
+		}
+		q.rOff = next
+	}
+	if err := q.writeCursor(); err != nil {
+		return err
+	}
+	return popErr
+}
+
+// Ready returns a channel that receives a value when records are appended.
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Close closes the queue's segment files.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	if q.r != nil {
+		q.r.Close()
+	}
+	if err := q.w.Close(); err != nil {
+		return errors.Wrap(err, "could not close queue segment")
+	}
+	return nil
+}
+
+// head reads the oldest record and the offset of the record after it,
+// advancing past and deleting consumed segments. It must be called with the
+// lock held.
+func (q *Queue) head() ([]byte, int64, error) {
+	for {
+		last := q.segments[len(q.segments)-1]
+		if q.r == nil {
+			r, err := os.Open(q.segmentPath(q.rSeg))
+			if err != nil {
+				return nil, 0, errors.Wrap(err, "could not open queue segment")
+			}
+			q.r = r
+		}
+
+		data, next, err := readRecord(q.r, q.rOff)
+		if err == nil {
+			return data, next, nil
+		}
+		if err != io.EOF {
+			return nil, 0, err
+		}
+		if q.rSeg == last {
+			return nil, 0, ErrEmpty
+		}
+
+		// The segment is consumed: move on to the next one.
+		q.r.Close()
+		q.r = nil
+		if err := os.Remove(q.segmentPath(q.rSeg)); err != nil && !os.IsNotExist(err) {
+			return nil, 0, errors.Wrap(err, "could not remove consumed queue segment")
+		}
+		q.segments = q.segments[1:]
+		q.rSeg, q.rOff = q.segments[0], 0
+		if err := q.writeCursor(); err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// truncate removes anything after the last complete record of the newest
+// segment. It must be called with the lock held.
+func (q *Queue) truncate() error {
+	if err := q.w.Truncate(q.wSize); err != nil {
+		return errors.Wrap(err, "could not truncate queue segment")
+	}
+	if _, err := q.w.Seek(q.wSize, io.SeekStart); err != nil {
+		return errors.Wrap(err, "could not seek queue segment")
+	}
+	q.torn = false
+	return nil
+}
+
+// rotate starts a new segment. It must be called with the lock held.
+func (q *Queue) rotate() error {
+	seq := q.segments[len(q.segments)-1] + 1
+	w, err := os.OpenFile(q.segmentPath(seq), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not create queue segment")
+	}
+	if err := q.w.Close(); err != nil {
+		w.Close()
+		return errors.Wrap(err, "could not close queue segment")
+	}
+	q.w, q.wSize = w, 0
+	q.segments = append(q.segments, seq)
+	return nil
+}
+
+func (q *Queue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, SegmentSuffix))
+}
+
+func (q *Queue) readCursor() (cursor, error) {
+	var c cursor
+	bytes, err := ioutil.ReadFile(filepath.Join(q.dir, CursorFile))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, errors.Wrap(err, "could not read queue cursor")
+	}
+	if err := json.Unmarshal(bytes, &c); err != nil {
+		return c, errors.Wrap(err, "could not unmarshal queue cursor")
+	}
+	return c, nil
+}
+
+// writeCursor atomically replaces the cursor file. It must be called with the
+// lock held.
+func (q *Queue) writeCursor() error {
+	bytes, err := json.Marshal(cursor{Segment: q.rSeg, Offset: q.rOff})
+	if err != nil {
+		return errors.Wrap(err, "could not marshal queue cursor")
+	}
+	tmp := filepath.Join(q.dir, CursorFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "could not create queue cursor")
+	}
+	if _, err := f.Write(bytes); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write queue cursor")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not sync queue cursor")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "could not close queue cursor")
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, CursorFile)); err != nil {
+		return errors.Wrap(err, "could not replace queue cursor")
+	}
+	return nil
+}
+
+// readRecord reads the record at offset in a segment, returning its payload
+// and the offset of the next record. It returns io.EOF if there is no complete
+// and valid record at offset.
+func readRecord(r io.ReaderAt, offset int64) ([]byte, int64, error) {
+	var header [headerSize]byte
+	if _, err := r.ReadAt(header[:], offset); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errors.Wrap(err, "could not read queue record header")
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size == 0 || size > MaxRecordSize {
+		return nil, 0, io.EOF
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, offset+headerSize); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errors.Wrap(err, "could not read queue record")
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, io.EOF
+	}
+	return data, offset + headerSize + int64(len(data)), nil
+}
+
+// validSize returns the length of the prefix of a segment that contains only
+// complete and valid records.
+func validSize(r io.ReaderAt) (int64, error) {
+	var offset int64
+	for {
+		_, next, err := readRecord(r, offset)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		offset = next
+	}
+}
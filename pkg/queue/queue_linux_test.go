@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// TestAppendDiscardsFailedWrite fills the disk partway through a record, by
+// limiting the size of files that the process can write.
+func TestAppendDiscardsFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("could not open queue: %s", err)
+	}
+	defer q.Close()
+	if err := q.Append([]byte("a")); err != nil {
+		t.Fatalf("could not append: %s", err)
+	}
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	full := limit
+	full.Cur = uint64(q.wSize + headerSize + 2)
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &full); err != nil {
+		t.Skipf("could not limit file size: %s", err)
+	}
+	err = q.Append([]byte("this record does not fit"))
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("appended a record that does not fit")
+	}
+
+	if err := q.Append([]byte("c")); err != nil {
+		t.Fatalf("could not append after a failed write: %s", err)
+	}
+	if got, want := drain(t, q), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+}
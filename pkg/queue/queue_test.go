@@ -0,0 +1,157 @@
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// drain reads and pops every record in the queue.
+func drain(t *testing.T, q *Queue) []string {
+	t.Helper()
+	var records []string
+	for {
+		data, err := q.Peek()
+		if err == ErrEmpty {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("could not peek: %s", err)
+		}
+		records = append(records, string(data))
+		if err := q.Pop(); err != nil {
+			t.Fatalf("could not pop: %s", err)
+		}
+	}
+}
+
+func TestOpenRecoversFromCrash(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// damage changes the segment file as a crash could.
+		damage func(t *testing.T, path string)
+	}{
+		{
+			name:   "clean",
+			damage: func(t *testing.T, path string) {},
+		},
+		{
+			name: "partial header",
+			damage: func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0})
+			},
+		},
+		{
+			name: "partial payload",
+			damage: func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0, 5, 1, 2, 3, 4, 'd', 'd'})
+			},
+		},
+		{
+			name: "bad checksum",
+			damage: func(t *testing.T, path string) {
+				appendBytes(t, path, []byte{0, 0, 0, 1, 1, 2, 3, 4, 'd'})
+			},
+		},
+		{
+			name: "zeroed tail",
+			damage: func(t *testing.T, path string) {
+				appendBytes(t, path, make([]byte, 64))
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "queue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			q, err := Open(dir, 0)
+			if err != nil {
+				t.Fatalf("could not open queue: %s", err)
+			}
+			for _, record := range []string{"a", "b", "c"} {
+				if err := q.Append([]byte(record)); err != nil {
+					t.Fatalf("could not append: %s", err)
+				}
+			}
+			q.Close()
+			test.damage(t, q.segmentPath(1))
+
+			q, err = Open(dir, 0)
+			if err != nil {
+				t.Fatalf("could not reopen queue: %s", err)
+			}
+			defer q.Close()
+			if err := q.Append([]byte("d")); err != nil {
+				t.Fatalf("could not append after reopening: %s", err)
+			}
+			if got, want := drain(t, q), []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got records %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestOpenResumesFromCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Small segments, so that consumed segments are deleted.
+	q, err := Open(dir, 16)
+	if err != nil {
+		t.Fatalf("could not open queue: %s", err)
+	}
+	for _, record := range []string{"one", "two", "three", "four"} {
+		if err := q.Append([]byte(record)); err != nil {
+			t.Fatalf("could not append: %s", err)
+		}
+	}
+	if err := q.PopN(3); err != nil {
+		t.Fatalf("could not pop: %s", err)
+	}
+	q.Close()
+
+	q, err = Open(dir, 16)
+	if err != nil {
+		t.Fatalf("could not reopen queue: %s", err)
+	}
+	defer q.Close()
+	if got, want := drain(t, q), []string{"four"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+}
+
+func TestAppendRejectsEmptyRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("could not open queue: %s", err)
+	}
+	defer q.Close()
+	if err := q.Append(nil); err != ErrNoData {
+		t.Errorf("got error %v, want %v", err, ErrNoData)
+	}
+}
+
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+// Package sensor defines a common interface for fish tank sensors, so that
+// fishmon can read temperature, pH, TDS and water level sensors alike.
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Conversion errors.
+var (
+	ErrIncompatibleUnits = errors.New("units are not convertible")
+)
+
+// ID uniquely identifies a sensor.
+type ID string
+
+// A Kind is the physical quantity that a sensor measures.
+type Kind string
+
+// Sensor kinds.
+const (
+	Temperature Kind = "temperature"
+	PH          Kind = "ph"
+	TDS         Kind = "tds"
+	WaterLevel  Kind = "water_level"
+	// TemperatureDifference is the kind of readings that measure a change or
+	// spread of temperature, which have no zero point to convert.
+	TemperatureDifference Kind = "temperature_difference"
+)
+
+// A Unit is the unit of measurement of a reading.
+type Unit string
+
+// Reading units.
+const (
+	Celsius     Unit = "°C"
+	Fahrenheit  Unit = "°F"
+	PHScale     Unit = "pH"
+	PPM         Unit = "ppm"
+	Centimeters Unit = "cm"
+)
+
+// A Sensor is a single device that takes readings.
+type Sensor interface {
+	// ID returns the sensor's identifier.
+	ID() ID
+	// Kind returns the quantity that the sensor measures.
+	Kind() Kind
+	// Unit returns the unit of the sensor's readings.
+	Unit() Unit
+	// Read takes a single reading.
+	Read(ctx context.Context) (Reading, error)
+}
+
+// A Reading is a single measurement taken by a sensor.
+type Reading struct {
+	Sensor ID
+	Kind   Kind
+	Value  float64
+	Unit   Unit
+	Time   time.Time
+}
+
+// Convert returns the reading in another unit.
+func (r Reading) Convert(unit Unit) (Reading, error) {
+	if r.Unit == unit {
+		return r, nil
+	}
+	switch {
+	case r.Kind == TemperatureDifference && r.Unit == Celsius && unit == Fahrenheit:
+		r.Value = r.Value * 1.8
+	case r.Kind == TemperatureDifference && r.Unit == Fahrenheit && unit == Celsius:
+		r.Value = r.Value / 1.8
+	case r.Unit == Celsius && unit == Fahrenheit:
+		r.Value = r.Value*1.8 + 32.0
+	case r.Unit == Fahrenheit && unit == Celsius:
+		r.Value = (r.Value - 32.0) / 1.8
+	default:
+		return r, errors.Wrapf(ErrIncompatibleUnits, "cannot convert %s to %s", r.Unit, unit)
+	}
+	r.Unit = unit
+	return r, nil
+}
+
+func (r Reading) String() string {
+	return fmt.Sprintf("%.3f%s", r.Value, r.Unit)
+}
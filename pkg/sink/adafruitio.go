@@ -0,0 +1,417 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/queue"
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// Upload retry backoff bounds.
+const (
+	MinBackoff = time.Second
+	MaxBackoff = 5 * time.Minute
+)
+
+// MaxBatchSize is the largest number of points uploaded in one request.
+const MaxBatchSize = 30
+
+// LiveWindow is the age under which points are published over MQTT, when it
+// is enabled. Older points are uploaded over HTTP, which keeps their original
+// timestamps.
+const LiveWindow = time.Minute
+
+// A Point is a reading that is queued for upload to an Adafruit.IO feed.
+type Point struct {
+	Feed      string    `json:"feed"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AdafruitIO is a sink that uploads readings to Adafruit.IO feeds. Readings
+// are queued on disk and uploaded in the background, so that they survive
+// network and Adafruit.IO outages. Each feed has its own queue in a
+// subdirectory of the sink's directory, so that a feed's backlog can be
+// uploaded in batches.
+type AdafruitIO struct {
+	// ErrorLog logs failed uploads. If nil, the standard logger is used.
+	ErrorLog *log.Logger
+	// TemperatureUnit is the unit that temperatures are uploaded in.
+	TemperatureUnit sensor.Unit
+	// Observe, if not nil, is called after each upload request with the
+	// feed, the number of points, how long the request took, and its error.
+	Observe func(feed string, points int, d time.Duration, err error)
+
+	dir       string
+	client    *adafruitio.Client
+	publisher *adafruitio.Publisher
+
+	mu     sync.Mutex
+	queues map[string]*queue.Queue
+	ready  chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Verify interfaces.
+var (
+	_ Sink = &AdafruitIO{}
+)
+
+// NewAdafruitIO opens the upload queues in dir, creating it if necessary. If
+// publisher is not nil, live points are published over MQTT. Call Start to
+// start uploading queued points.
+func NewAdafruitIO(dir string, client *adafruitio.Client, publisher *adafruitio.Publisher) (*AdafruitIO, error) {
+	a := &AdafruitIO{
+		TemperatureUnit: sensor.Fahrenheit,
+		dir:             dir,
+		client:          client,
+		publisher:       publisher,
+		queues:          make(map[string]*queue.Queue),
+		ready:           make(chan struct{}, 1),
+		done:            make(chan struct{}),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create upload queue directory %s", dir)
+	}
+
+	// Open existing feed queues.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read upload queue directory %s", dir)
+	}
+	legacy := false
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), queue.SegmentSuffix) {
+			legacy = true
+		}
+		if !file.IsDir() {
+			continue
+		}
+		feed, err := url.PathUnescape(file.Name())
+		if err != nil {
+			continue
+		}
+		if _, err := a.queue(feed); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+
+	// Older versions kept a single queue for all feeds in the directory itself.
+	if legacy {
+		if err := a.migrate(); err != nil {
+			a.Close()
+			return nil, err
+		}
+	}
+
+	a.signal()
+	return a, nil
+}
+
+// Start starts uploading queued points in the background. The sink's fields
+// must not be changed after calling Start.
+func (a *AdafruitIO) Start() {
+	a.wg.Add(1)
+	go a.run()
+}
+
+// Name returns "Adafruit.IO".
+func (a *AdafruitIO) Name() string {
+	return "Adafruit.IO"
+}
+
+// Send queues a reading for upload to its feed.
+func (a *AdafruitIO) Send(ctx context.Context, r Reading) error {
+	if r.Feed == "" {
+		return errors.Wrapf(ErrNoFeed, "sensor %s", r.Sensor)
+	}
+	reading := r.Reading
+	if reading.Kind == sensor.Temperature || reading.Kind == sensor.TemperatureDifference {
+		var err error
+		reading, err = reading.Convert(a.TemperatureUnit)
+		if err != nil {
+			return err
+		}
+	}
+	return a.Enqueue(Point{
+		Feed:      r.Feed,
+		Value:     fmt.Sprintf("%.3f", reading.Value),
+		CreatedAt: reading.Time,
+	})
+}
+
+func (a *AdafruitIO) logf(format string, args ...interface{}) {
+	if a.ErrorLog != nil {
+		a.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// migrate moves points from a single queue for all feeds into feed queues.
+func (a *AdafruitIO) migrate() error {
+	q, err := queue.Open(a.dir, queue.DefaultSegmentSize)
+	if err != nil {
+		return errors.Wrap(err, "could not open legacy upload queue")
+	}
+	for {
+		data, err := q.Peek()
+		if err == queue.ErrEmpty {
+			break
+		}
+		if err != nil {
+			q.Close()
+			return errors.Wrap(err, "could not read legacy upload queue")
+		}
+		var point Point
+		if err := json.Unmarshal(data, &point); err == nil {
+			if err := a.Enqueue(point); err != nil {
+				q.Close()
+				return err
+			}
+		}
+		if err := q.Pop(); err != nil {
+			q.Close()
+			return errors.Wrap(err, "could not remove point from legacy upload queue")
+		}
+	}
+	q.Close()
+
+	// Remove the emptied legacy queue.
+	files, err := filepath.Glob(filepath.Join(a.dir, "*"+queue.SegmentSuffix))
+	if err != nil {
+		return errors.Wrap(err, "could not list legacy upload queue segments")
+	}
+	for _, file := range append(files, filepath.Join(a.dir, queue.CursorFile)) {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "could not remove legacy upload queue")
+		}
+	}
+	return nil
+}
+
+// queue returns the queue for a feed, opening it if necessary.
+func (a *AdafruitIO) queue(feed string) (*queue.Queue, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if q, ok := a.queues[feed]; ok {
+		return q, nil
+	}
+	dir := filepath.Join(a.dir, url.PathEscape(feed))
+	q, err := queue.Open(dir, queue.DefaultSegmentSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open upload queue for feed %s", feed)
+	}
+	a.queues[feed] = q
+	return q, nil
+}
+
+// feeds returns the feeds that have queues, in order.
+func (a *AdafruitIO) feeds() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var feeds []string
+	for feed := range a.queues {
+		feeds = append(feeds, feed)
+	}
+	sort.Strings(feeds)
+	return feeds
+}
+
+func (a *AdafruitIO) signal() {
+	select {
+	case a.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue durably queues a point for upload.
+func (a *AdafruitIO) Enqueue(point Point) error {
+	q, err := a.queue(point.Feed)
+	if err != nil {
+		return err
+	}
+	if err := q.AppendJSON(point); err != nil {
+		return err
+	}
+	a.signal()
+	return nil
+}
+
+// A retry is the backoff of a feed whose last upload failed.
+type retry struct {
+	backoff time.Duration
+	at      time.Time
+}
+
+// run uploads queued points in order, until the sink is closed. Backlogged
+// points are uploaded in batches. Each feed's failed uploads are retried with
+// exponential backoff, so that a failing feed neither delays nor is hammered
+// by the uploads of other feeds.
+func (a *AdafruitIO) run() {
+	defer a.wg.Done()
+	retries := make(map[string]*retry)
+	for {
+		// Throttled clients already wait before their next request, so
+		// throttled feeds are retried immediately.
+		again := false
+		var next time.Time
+		for _, feed := range a.feeds() {
+			r := retries[feed]
+			if r != nil && time.Now().Before(r.at) {
+				if next.IsZero() || r.at.Before(next) {
+					next = r.at
+				}
+				continue
+			}
+			ok, err := a.upload(feed)
+			if err == nil {
+				delete(retries, feed)
+				again = again || ok
+				continue
+			}
+			a.logf("failed to upload points to feed %s: %s", feed, err.Error())
+			if _, ok := errors.Cause(err).(*adafruitio.ThrottleError); ok {
+				again = true
+				continue
+			}
+
+			// Retry with jittered exponential backoff.
+			if r == nil {
+				r = &retry{backoff: MinBackoff}
+				retries[feed] = r
+			}
+			wait := r.backoff/2 + time.Duration(rand.Int63n(int64(r.backoff/2)+1))
+			a.logf("retrying uploads to feed %s in %s", feed, wait)
+			r.at = time.Now().Add(wait)
+			if next.IsZero() || r.at.Before(next) {
+				next = r.at
+			}
+			r.backoff *= 2
+			if r.backoff > MaxBackoff {
+				r.backoff = MaxBackoff
+			}
+		}
+		if again {
+			continue
+		}
+
+		// Wait for new points, or for the next retry.
+		var retryAt <-chan time.Time
+		if !next.IsZero() {
+			retryAt = time.After(time.Until(next))
+		}
+		select {
+		case <-a.done:
+			return
+		case <-a.ready:
+		case <-retryAt:
+		}
+	}
+}
+
+// upload uploads the oldest batch of points queued for a feed. It returns
+// whether any points were uploaded or dropped. Points that Adafruit.IO rejects
+// permanently, such as points for a feed that does not exist, are dropped, so
+// that they do not block the rest of the feed's queue.
+func (a *AdafruitIO) upload(feed string) (bool, error) {
+	q, err := a.queue(feed)
+	if err != nil {
+		return false, err
+	}
+	records, err := q.PeekN(MaxBatchSize)
+	if err == queue.ErrEmpty {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "could not read upload queue")
+	}
+
+	// Decode points, stopping at the first unreadable point.
+	var batch []adafruitio.DataRequest
+	for _, record := range records {
+		var point Point
+		if err := json.Unmarshal(record, &point); err != nil {
+			break
+		}
+		batch = append(batch, adafruitio.DataRequest{
+			Value:     point.Value,
+			CreatedAt: point.CreatedAt,
+		})
+	}
+	if len(batch) == 0 {
+		// Corrupt points can never be uploaded, so drop them.
+		a.logf("dropping unreadable queued point for feed %s", feed)
+		return false, errors.Wrap(q.Pop(), "could not remove point from upload queue")
+	}
+
+	// Upload points.
+	start := time.Now()
+	switch {
+	// Live points go over MQTT while it is connected, and over HTTP otherwise.
+	case len(batch) == 1 && a.publisher != nil && a.publisher.Connected() && time.Since(batch[0].CreatedAt) < LiveWindow:
+		ctx, cancel := context.WithTimeout(context.Background(), adafruitio.DefaultTimeout)
+		err = a.publisher.Publish(ctx, feed, batch[0].Value)
+		cancel()
+	case len(batch) == 1:
+		err = a.client.Record(feed, batch[0].Value, batch[0].CreatedAt)
+	default:
+		err = a.client.RecordBatch(feed, batch)
+	}
+	if a.Observe != nil {
+		a.Observe(feed, len(batch), time.Since(start), err)
+	}
+	if apiErr, ok := errors.Cause(err).(*adafruitio.APIError); ok && apiErr.Permanent() {
+		a.logf("dropping %d queued points rejected by feed %s: %s", len(batch), feed, err.Error())
+		return true, errors.Wrap(q.PopN(len(batch)), "could not remove points from upload queue")
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := q.PopN(len(batch)); err != nil {
+		return true, errors.Wrap(err, "could not remove points from upload queue")
+	}
+	return true, nil
+}
+
+// Close stops uploading, and closes the sink's queues and MQTT publisher.
+// Queued points are uploaded when the sink is next opened.
+func (a *AdafruitIO) Close() error {
+	select {
+	case <-a.done:
+	default:
+		close(a.done)
+	}
+	a.wg.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	if a.publisher != nil {
+		err = a.publisher.Close()
+	}
+	for _, q := range a.queues {
+		if cerr := q.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
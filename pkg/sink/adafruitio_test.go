@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/adafruitio"
+	"github.com/goodbuns/fishmon/pkg/adafruitio/aiotest"
+	"github.com/goodbuns/fishmon/pkg/queue"
+)
+
+func TestAdafruitIORejectedPoints(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		key     string
+		feed    string
+		dropped bool
+	}{
+		{name: "uploaded", key: "secret", feed: "fish.tank", dropped: true},
+		{name: "no such feed", key: "secret", feed: "fish.missing", dropped: true},
+		{name: "wrong key", key: "guess", feed: "fish.tank"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := aiotest.NewServer()
+			defer s.Close()
+			s.AddUser("fish", "secret")
+			s.AddFeed("fish", "fish", "fish.tank", "tank", false)
+			s.AddFeed("fish", "fish", "fish.other", "other", false)
+
+			dir, err := ioutil.TempDir("", "sink")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			client := adafruitio.NewUnchecked("fish", test.key, adafruitio.WithBaseURL(s.BaseURL()))
+			a, err := NewAdafruitIO(dir, client, nil)
+			if err != nil {
+				t.Fatalf("could not open sink: %s", err)
+			}
+			defer a.Close()
+			a.ErrorLog = log.New(ioutil.Discard, "", 0)
+			for _, feed := range []string{test.feed, "fish.other"} {
+				if err := a.Enqueue(Point{Feed: feed, Value: "75.000", CreatedAt: time.Now()}); err != nil {
+					t.Fatalf("could not queue point: %s", err)
+				}
+			}
+			a.Start()
+
+			// Wait until both feeds have been tried.
+			deadline := time.Now().Add(5 * time.Second)
+			for len(s.Requests()) < 2 {
+				if time.Now().After(deadline) {
+					t.Fatal("timed out waiting for uploads")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			a.Close()
+
+			// Reopen the queues to check what is left in them.
+			a, err = NewAdafruitIO(dir, client, nil)
+			if err != nil {
+				t.Fatalf("could not reopen sink: %s", err)
+			}
+			defer a.Close()
+			q, err := a.queue(test.feed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := q.Peek(); (err == queue.ErrEmpty) != test.dropped {
+				t.Errorf("got queue error %v, want points dropped %t", err, test.dropped)
+			}
+			if test.key == "secret" && len(s.Points("fish", "fish.other")) != 1 {
+				t.Errorf("points for another feed were not uploaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// File is a sink that appends readings to a local file, one JSON object per
+// line.
+//
+//	{"time":"2019-06-01T12:00:00Z","sensor":"28-02089245bf26","name":"left tank","kind":"temperature","value":24.5,"unit":"°C"}
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Verify interfaces.
+var (
+	_ Sink = &File{}
+)
+
+// NewFile opens a file for appending readings, creating it if necessary.
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open readings file %s", path)
+	}
+	return &File{path: path, f: f}, nil
+}
+
+// Name returns the path of the file.
+func (s *File) Name() string {
+	return "file " + s.path
+}
+
+// Send appends a reading to the file.
+func (s *File) Send(ctx context.Context, r Reading) error {
+	line, err := marshalReading(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return errors.Wrapf(err, "could not write reading to %s", s.path)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Close(); err != nil {
+		return errors.Wrapf(err, "could not close readings file %s", s.path)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// post sends a request body to an HTTP endpoint, and checks that the response
+// status is successful.
+func post(ctx context.Context, client *http.Client, method, url, contentType string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not construct request")
+	}
+	req = req.WithContext(ctx)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not send request")
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return errors.Errorf("request failed with status %s: %s", res.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultMeasurement is the InfluxDB measurement that readings are written to.
+const DefaultMeasurement = "fishmon"
+
+// InfluxDB is a sink that writes readings to InfluxDB using the line protocol
+// over HTTP.
+type InfluxDB struct {
+	// URL is the write endpoint, including its query parameters. For example,
+	// http://localhost:8086/write?db=fishmon for InfluxDB 1.x, or
+	// http://localhost:8086/api/v2/write?org=home&bucket=fishmon for 2.x.
+	URL string
+	// Token, if set, is sent as an authorization token.
+	Token string
+	// Measurement is the measurement name. If empty, DefaultMeasurement is
+	// used.
+	Measurement string
+	// Client sends requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Verify interfaces.
+var (
+	_ Sink = &InfluxDB{}
+)
+
+// Name returns "InfluxDB".
+func (s *InfluxDB) Name() string {
+	return "InfluxDB"
+}
+
+// Send writes a reading as a single point, tagged with the sensor's ID, name,
+// kind and unit, and the reading's statistic if any.
+func (s *InfluxDB) Send(ctx context.Context, r Reading) error {
+	measurement := s.Measurement
+	if measurement == "" {
+		measurement = DefaultMeasurement
+	}
+	tags := []string{
+		escapeInflux(measurement, ", "),
+		"sensor=" + escapeInflux(string(r.Sensor), ",= "),
+		"kind=" + escapeInflux(string(r.Kind), ",= "),
+		"unit=" + escapeInflux(string(r.Unit), ",= "),
+	}
+	if r.Name != "" {
+		tags = append(tags, "name="+escapeInflux(r.Name, ",= "))
+	}
+	if r.Statistic != "" {
+		tags = append(tags, "statistic="+escapeInflux(r.Statistic, ",= "))
+	}
+	line := fmt.Sprintf("%s value=%g %d\n", strings.Join(tags, ","), r.Value, r.Time.UnixNano())
+
+	header := make(http.Header)
+	if s.Token != "" {
+		header.Set("Authorization", "Token "+s.Token)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return post(ctx, client, http.MethodPost, s.URL, "text/plain; charset=utf-8", header, []byte(line))
+}
+
+// Close does nothing.
+func (s *InfluxDB) Close() error {
+	return nil
+}
+
+// escapeInflux escapes special characters in a line protocol identifier.
+func escapeInflux(s, special string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c == '\\' || strings.ContainsRune(special, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/goodbuns/fishmon/pkg/mqtt"
+)
+
+// DefaultTopicPrefix is the MQTT topic prefix that readings are published
+// under.
+const DefaultTopicPrefix = "fishmon/"
+
+// MQTT is a sink that publishes readings to an MQTT server as JSON objects, on
+// one topic per sensor. Readings have the same representation as in File.
+type MQTT struct {
+	// TopicPrefix prefixes the sensor ID to form each topic. If empty,
+	// DefaultTopicPrefix is used.
+	TopicPrefix string
+	// Retain sets whether the server retains each sensor's latest reading.
+	Retain bool
+
+	client *mqtt.Client
+}
+
+// Verify interfaces.
+var (
+	_ Sink = &MQTT{}
+)
+
+// NewMQTT connects to an MQTT server.
+func NewMQTT(opts mqtt.Options) (*MQTT, error) {
+	client, err := mqtt.Dial(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MQTT{client: client}, nil
+}
+
+// Name returns "MQTT".
+func (s *MQTT) Name() string {
+	return "MQTT"
+}
+
+// Send publishes a reading with QoS 1 to the sensor's topic, or to a subtopic
+// for statistics other than the sensor's main reading.
+func (s *MQTT) Send(ctx context.Context, r Reading) error {
+	prefix := s.TopicPrefix
+	if prefix == "" {
+		prefix = DefaultTopicPrefix
+	}
+	payload, err := marshalReading(r)
+	if err != nil {
+		return err
+	}
+	topic := prefix + string(r.Sensor)
+	if r.Statistic != "" {
+		topic += "/" + r.Statistic
+	}
+	return s.client.Publish(ctx, topic, payload, 1, s.Retain)
+}
+
+// Close disconnects from the MQTT server.
+func (s *MQTT) Close() error {
+	return s.client.Close()
+}
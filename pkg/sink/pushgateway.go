@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultJob is the Prometheus job name that readings are pushed under.
+const DefaultJob = "fishmon"
+
+// Pushgateway is a sink that pushes the latest reading of each sensor to a
+// Prometheus Pushgateway, as a gauge grouped by sensor.
+type Pushgateway struct {
+	// URL is the base URL of the Pushgateway, such as http://localhost:9091.
+	URL string
+	// Job is the job name. If empty, DefaultJob is used.
+	Job string
+	// Client sends requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Verify interfaces.
+var (
+	_ Sink = &Pushgateway{}
+)
+
+// Name returns "Pushgateway".
+func (s *Pushgateway) Name() string {
+	return "Pushgateway"
+}
+
+// Send pushes a reading as the fishmon_reading gauge, replacing the previous
+// reading of the same sensor and statistic.
+func (s *Pushgateway) Send(ctx context.Context, r Reading) error {
+	job := s.Job
+	if job == "" {
+		job = DefaultJob
+	}
+	endpoint := strings.TrimSuffix(s.URL, "/") +
+		"/metrics/job/" + url.PathEscape(job) +
+		"/sensor/" + url.PathEscape(string(r.Sensor))
+	if r.Statistic != "" {
+		endpoint += "/statistic/" + url.PathEscape(r.Statistic)
+	}
+
+	body := fmt.Sprintf(`# TYPE fishmon_reading gauge
+fishmon_reading{kind="%s",unit="%s",name="%s"} %g
+# TYPE fishmon_reading_timestamp_seconds gauge
+fishmon_reading_timestamp_seconds %d
+`, escapeLabel(string(r.Kind)), escapeLabel(string(r.Unit)), escapeLabel(r.Name), r.Value, r.Time.Unix())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return post(ctx, client, http.MethodPut, endpoint, "text/plain; version=0.0.4", nil, []byte(body))
+}
+
+// Close does nothing.
+func (s *Pushgateway) Close() error {
+	return nil
+}
+
+// escapeLabel escapes a label value in the Prometheus text format.
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
@@ -0,0 +1,168 @@
+// Package sink implements destinations for sensor readings, and fans readings
+// out to several destinations at once.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// Fanout defaults.
+const (
+	DefaultBufferSize  = 256
+	DefaultSendTimeout = time.Minute
+)
+
+// Sink errors.
+var (
+	ErrBufferFull = errors.New("sink buffer is full")
+	ErrNoFeed     = errors.New("no Adafruit.IO feed configured")
+)
+
+// A Reading is a sensor reading, labelled with its sensor's configuration.
+type Reading struct {
+	sensor.Reading
+
+	// Name is the configured human-readable name of the sensor.
+	Name string
+	// Feed is the Adafruit.IO feed key configured for the sensor.
+	Feed string
+	// Statistic is the statistic of the sensor's readings that the reading
+	// holds, such as "max". It is empty for the sensor's main reading.
+	Statistic string
+}
+
+// jsonReading is the JSON representation of a reading.
+type jsonReading struct {
+	Time      time.Time   `json:"time"`
+	Sensor    sensor.ID   `json:"sensor"`
+	Name      string      `json:"name,omitempty"`
+	Statistic string      `json:"statistic,omitempty"`
+	Kind      sensor.Kind `json:"kind"`
+	Value     float64     `json:"value"`
+	Unit      sensor.Unit `json:"unit"`
+}
+
+func marshalReading(r Reading) ([]byte, error) {
+	b, err := json.Marshal(jsonReading{
+		Time:      r.Time,
+		Sensor:    r.Sensor,
+		Name:      r.Name,
+		Statistic: r.Statistic,
+		Kind:      r.Kind,
+		Value:     r.Value,
+		Unit:      r.Unit,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal reading")
+	}
+	return b, nil
+}
+
+// A Sink sends readings to a destination.
+type Sink interface {
+	// Name returns a short description of the sink, for logging.
+	Name() string
+	// Send sends a single reading.
+	Send(ctx context.Context, r Reading) error
+	// Close releases the sink's resources.
+	Close() error
+}
+
+// A Fanout sends each reading to several sinks. Each sink has its own buffer
+// and goroutine, so that a slow or failing sink does not delay the others.
+type Fanout struct {
+	// ErrorLog logs failed sends. If nil, the standard logger is used.
+	ErrorLog *log.Logger
+	// Observe, if not nil, is called after each send with the sink, how long
+	// the send took, and its error. Readings dropped because a sink's buffer
+	// is full are observed with ErrBufferFull.
+	Observe func(s Sink, d time.Duration, err error)
+
+	timeout time.Duration
+	workers []*worker
+	wg      sync.WaitGroup
+}
+
+type worker struct {
+	sink     Sink
+	readings chan Reading
+}
+
+// NewFanout starts sending readings to sinks. Each sink buffers up to
+// bufferSize readings, and each send times out after timeout.
+func NewFanout(bufferSize int, timeout time.Duration, sinks ...Sink) *Fanout {
+	f := &Fanout{timeout: timeout}
+	for _, s := range sinks {
+		w := &worker{
+			sink:     s,
+			readings: make(chan Reading, bufferSize),
+		}
+		f.workers = append(f.workers, w)
+		f.wg.Add(1)
+		go f.run(w)
+	}
+	return f
+}
+
+func (f *Fanout) run(w *worker) {
+	defer f.wg.Done()
+	for r := range w.readings {
+		ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+		start := time.Now()
+		err := w.sink.Send(ctx, r)
+		cancel()
+		if f.Observe != nil {
+			f.Observe(w.sink, time.Since(start), err)
+		}
+		if err != nil {
+			f.logf("failed to send reading for sensor %s to %s: %s", r.Sensor, w.sink.Name(), err.Error())
+		}
+	}
+}
+
+func (f *Fanout) logf(format string, args ...interface{}) {
+	if f.ErrorLog != nil {
+		f.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Send queues a reading for every sink without blocking. Readings are dropped
+// for sinks whose buffers are full.
+func (f *Fanout) Send(r Reading) {
+	for _, w := range f.workers {
+		select {
+		case w.readings <- r:
+		default:
+			if f.Observe != nil {
+				f.Observe(w.sink, 0, ErrBufferFull)
+			}
+			f.logf("dropped reading for sensor %s: %s buffer is full", r.Sensor, w.sink.Name())
+		}
+	}
+}
+
+// Close waits for buffered readings to be sent, then closes every sink.
+func (f *Fanout) Close() error {
+	for _, w := range f.workers {
+		close(w.readings)
+	}
+	f.wg.Wait()
+
+	var err error
+	for _, w := range f.workers {
+		if cerr := w.sink.Close(); cerr != nil && err == nil {
+			err = errors.Wrapf(cerr, "could not close %s", w.sink.Name())
+		}
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodbuns/fishmon/pkg/sensor"
+)
+
+// A fakeSink records the readings sent to it.
+type fakeSink struct {
+	name string
+	// err is returned by every send.
+	err error
+	// block, if not nil, delays sends until it is closed.
+	block    chan struct{}
+	closeErr error
+
+	mu       sync.Mutex
+	readings []sensor.ID
+	closed   bool
+}
+
+func (s *fakeSink) Name() string {
+	return s.name
+}
+
+func (s *fakeSink) Send(ctx context.Context, r Reading) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings = append(s.readings, r.Sensor)
+	return s.err
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.closeErr
+}
+
+func TestFanout(t *testing.T) {
+	errSend := errors.New("send failed")
+	readings := []sensor.ID{"28-1", "28-2", "28-3", "28-4"}
+	for _, test := range []struct {
+		name  string
+		sinks []*fakeSink
+		// bufferSize is the size of each sink's buffer. If zero, it is the
+		// number of readings.
+		bufferSize int
+		// want maps sink names to the readings they receive.
+		want map[string][]sensor.ID
+		// errors maps sink names to the errors observed for their sends.
+		errors map[string][]error
+	}{
+		{
+			name:  "every sink",
+			sinks: []*fakeSink{{name: "a"}, {name: "b"}},
+			want:  map[string][]sensor.ID{"a": readings, "b": readings},
+		},
+		{
+			name:   "failing sink",
+			sinks:  []*fakeSink{{name: "a", err: errSend}, {name: "b"}},
+			want:   map[string][]sensor.ID{"a": readings, "b": readings},
+			errors: map[string][]error{"a": {errSend, errSend, errSend, errSend}},
+		},
+		{
+			name:       "full buffer",
+			sinks:      []*fakeSink{{name: "a", block: make(chan struct{})}, {name: "b"}},
+			bufferSize: 2,
+			// The blocked sink takes one reading and buffers two more.
+			want:   map[string][]sensor.ID{"a": readings[:3], "b": readings},
+			errors: map[string][]error{"a": {ErrBufferFull}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var sinks []Sink
+			for _, s := range test.sinks {
+				sinks = append(sinks, s)
+			}
+			bufferSize := test.bufferSize
+			if bufferSize == 0 {
+				bufferSize = len(readings)
+			}
+			f := NewFanout(bufferSize, time.Second, sinks...)
+			f.ErrorLog = log.New(ioutil.Discard, "", 0)
+			var mu sync.Mutex
+			observed := make(map[string][]error)
+			f.Observe = func(s Sink, d time.Duration, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					observed[s.Name()] = append(observed[s.Name()], err)
+				}
+			}
+
+			for i, id := range readings {
+				f.Send(Reading{Reading: sensor.Reading{Sensor: id}})
+				// Wait for the blocked sink to take the first reading.
+				if i == 0 && test.bufferSize != 0 {
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
+			for _, s := range test.sinks {
+				if s.block != nil {
+					close(s.block)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("could not close: %s", err)
+			}
+
+			for _, s := range test.sinks {
+				if !reflect.DeepEqual(s.readings, test.want[s.name]) {
+					t.Errorf("sink %s got readings %q, want %q", s.name, s.readings, test.want[s.name])
+				}
+				if !reflect.DeepEqual(observed[s.name], test.errors[s.name]) {
+					t.Errorf("sink %s got errors %v, want %v", s.name, observed[s.name], test.errors[s.name])
+				}
+				if !s.closed {
+					t.Errorf("sink %s was not closed", s.name)
+				}
+			}
+		})
+	}
+}
+
+func TestFanoutCloseError(t *testing.T) {
+	errClose := errors.New("close failed")
+	a := &fakeSink{name: "a", closeErr: errClose}
+	b := &fakeSink{name: "b"}
+	f := NewFanout(DefaultBufferSize, DefaultSendTimeout, a, b)
+	if err := f.Close(); err == nil {
+		t.Errorf("got no error, want %v", errClose)
+	}
+	if !b.closed {
+		t.Errorf("sink b was not closed after sink a failed to close")
+	}
+}